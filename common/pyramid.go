@@ -0,0 +1,75 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// PairIndex returns the position of the pair (i,j) in the flat pyramid order
+// produced by IterPairs. The order of i and j does not matter.
+// Panics if i=j.
+func PairIndex(i, j int) int {
+	if i == j {
+		panic(fmt.Sprintf("i=j is not allowed (i=j=%v)", i))
+	}
+	if i < j {
+		i, j = j, i
+	}
+	return i*(i-1)/2 + j
+}
+
+// NumElements returns the number of elements whose pairs make a flat pyramid
+// of the given length. Returns an error if no such number exists.
+func NumElements(npairs int) (int, error) {
+	n := int(math.Round((1 + math.Sqrt(1+8*float64(npairs))) / 2))
+	if n*(n-1)/2 != npairs {
+		return 0, fmt.Errorf("%d values do not make a full distance matrix",
+			npairs)
+	}
+	return n, nil
+}
+
+// ReadDistances reads a flat pyramid of distances, one value per line, as
+// written by frcfrc.
+func ReadDistances(r io.Reader) ([]float64, error) {
+	var result []float64
+	for d, err := range IterDistances(r) {
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, d)
+	}
+	return result, nil
+}
+
+// IterDistances iterates over a flat pyramid of distances, one value per
+// line, as written by frcfrc.
+func IterDistances(r io.Reader) iter.Seq2[float64, error] {
+	return func(yield func(float64, error) bool) {
+		sc := bufio.NewScanner(r)
+		i := 0
+		for sc.Scan() {
+			i++
+			line := strings.TrimSpace(sc.Text())
+			if line == "" {
+				continue
+			}
+			f, err := strconv.ParseFloat(line, 64)
+			if err != nil {
+				yield(0, fmt.Errorf("line #%d: %v", i, err))
+				return
+			}
+			if !yield(f, nil) {
+				return
+			}
+		}
+		if err := sc.Err(); err != nil {
+			yield(0, err)
+		}
+	}
+}
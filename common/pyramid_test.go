@@ -0,0 +1,65 @@
+package common
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPairIndex(t *testing.T) {
+	s := []int{0, 1, 2, 3, 4}
+	i := 0
+	for p := range IterPairs(s) {
+		if got := PairIndex(p[0], p[1]); got != i {
+			t.Errorf("PairIndex(%v,%v)=%v, want %v", p[0], p[1], got, i)
+		}
+		if got := PairIndex(p[1], p[0]); got != i {
+			t.Errorf("PairIndex(%v,%v)=%v, want %v", p[1], p[0], got, i)
+		}
+		i++
+	}
+}
+
+func TestPairIndex_equal(t *testing.T) {
+	defer func() { recover() }()
+	n := PairIndex(2, 2)
+	t.Fatalf("PairIndex(2,2)=%v, want panic", n)
+}
+
+func TestNumElements(t *testing.T) {
+	tests := []struct {
+		npairs, want int
+	}{
+		{0, 1}, {1, 2}, {3, 3}, {6, 4}, {4950, 100},
+	}
+	for _, test := range tests {
+		got, err := NumElements(test.npairs)
+		if err != nil {
+			t.Errorf("NumElements(%v) failed: %v", test.npairs, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("NumElements(%v)=%v, want %v", test.npairs, got, test.want)
+		}
+	}
+}
+
+func TestNumElements_bad(t *testing.T) {
+	for _, npairs := range []int{2, 4, 5, 7, 4951} {
+		if got, err := NumElements(npairs); err == nil {
+			t.Errorf("NumElements(%v)=%v, want error", npairs, got)
+		}
+	}
+}
+
+func TestReadDistances(t *testing.T) {
+	input := "0.5\n1\n\n 0.25 \n"
+	want := []float64{0.5, 1, 0.25}
+	got, err := ReadDistances(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ReadDistances(%q) failed: %v", input, err)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("ReadDistances(%q)=%v, want %v", input, got, want)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fluhus/biostuff/formats/newick"
+)
+
+// A single node's contribution to the distance between two samples.
+type contribution struct {
+	id    int     // Node unique ID.
+	a, b  float64 // Abundances under this node in each sample.
+	numer float64 // Contribution to the distance's numerator.
+	denom float64 // Contribution to the distance's denominator.
+}
+
+// Parses the pair of 1-based sample numbers given to -x. Returns them as
+// 0-based indexes.
+func parseExplainPair(s string) (int, int, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("bad sample pair: %q, want 2 numbers "+
+			"separated by a comma", s)
+	}
+	var result [2]int
+	for i := range parts {
+		a, err := strconv.Atoi(strings.TrimSpace(parts[i]))
+		if err != nil {
+			return 0, 0, fmt.Errorf("bad sample pair: %q: %v", s, err)
+		}
+		if a < 1 {
+			return 0, 0, fmt.Errorf("bad sample number: %d", a)
+		}
+		result[i] = a - 1
+	}
+	if result[0] == result[1] {
+		return 0, 0, fmt.Errorf("cannot explain a sample with itself")
+	}
+	return result[0], result[1], nil
+}
+
+// Returns the index in ids of the sample with the given 0-based number in the
+// input, where allIDs are the IDs of all the input samples.
+func sampleIndex(ids, allIDs []string, num int) (int, error) {
+	if num >= len(allIDs) {
+		return 0, fmt.Errorf("sample number %d is out of range, have %d "+
+			"samples", num+1, len(allIDs))
+	}
+	for i := range ids {
+		if ids[i] == allIDs[num] {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("sample #%d was filtered out", num+1)
+}
+
+// Returns each node's contribution to the UniFrac distance between a and b,
+// sorted by descending numerator contribution. Nodes that are absent from both
+// samples are omitted.
+func explainDist(a, b []flatNode, treeDists []float64, weighted bool,
+) []contribution {
+	var result []contribution
+	add := func(id int, aa, ab float64) {
+		c := contribution{id: id, a: aa, b: ab}
+		if weighted {
+			c.numer = treeDists[id] * math.Abs(aa-ab)
+			c.denom = treeDists[id] * (aa + ab)
+		} else {
+			if aa == 0 || ab == 0 {
+				c.numer = treeDists[id]
+			}
+			c.denom = treeDists[id]
+		}
+		result = append(result, c)
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i].id < b[j].id {
+			add(a[i].id, a[i].abnd, 0)
+			i++
+			continue
+		}
+		if a[i].id > b[j].id {
+			add(b[j].id, 0, b[j].abnd)
+			j++
+			continue
+		}
+		add(a[i].id, a[i].abnd, b[j].abnd)
+		i++
+		j++
+	}
+	for _, x := range a[i:] {
+		add(x.id, x.abnd, 0)
+	}
+	for _, x := range b[j:] {
+		add(x.id, 0, x.abnd)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].numer > result[j].numer
+	})
+	return result
+}
+
+// Writes the per-node breakdown of the distance between samples i and j,
+// normalized by norms if it is not nil. Writes an annotated tree to ftree if
+// it is not empty.
+func explain(abnd []map[string]float64, tree *newick.Node, norms []float64,
+	i, j int, weighted bool, w io.Writer, ftree string) error {
+	if i >= len(abnd) || j >= len(abnd) {
+		return fmt.Errorf("sample number %d is out of range, have %d samples",
+			max(i, j)+1, len(abnd))
+	}
+	if norms != nil {
+		norms = []float64{norms[i], norms[j]}
+	}
+	sets, treeDists := toFlatNodes([]map[string]float64{abnd[i], abnd[j]},
+		tree, norms)
+	contribs := explainDist(sets[0], sets[1], treeDists, weighted)
+	var nodes []*newick.Node // By ID.
+	for n := range tree.PreOrder() {
+		nodes = append(nodes, n)
+	}
+
+	numer, denom := 0.0, 0.0
+	for _, c := range contribs {
+		numer += c.numer
+		denom += c.denom
+	}
+	fmt.Fprintf(os.Stderr, "Distance between samples #%d and #%d: %v\n",
+		i+1, j+1, numer/denom)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "node\tname\tbranch_length\tabundance_1\tabundance_2\t"+
+		"numerator\tdenominator\tcontribution")
+	for _, c := range contribs {
+		fmt.Fprintf(bw, "%d\t%s\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			c.id, nodes[c.id].Name, treeDists[c.id], c.a, c.b,
+			c.numer, c.denom, c.numer/denom)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if ftree == "" {
+		return nil
+	}
+	for _, c := range contribs {
+		nodes[c.id].Name = fmt.Sprintf("%s[&contribution=%g]",
+			nodes[c.id].Name, c.numer/denom)
+	}
+	treeText, _ := tree.MarshalText()
+	return os.WriteFile(ftree, treeText, 0o644)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fluhus/gostuff/aio"
+)
+
+// A metadata condition for selecting samples, parsed from -where.
+type condition struct {
+	column string // Metadata column name.
+	value  string // Value to compare to.
+	equal  bool   // Whether the value should be equal or not equal.
+}
+
+// Parses a comma-separated list of conditions of the form column=value or
+// column!=value.
+func parseConditions(s string) ([]condition, error) {
+	var result []condition
+	for _, part := range strings.Split(s, ",") {
+		c := condition{equal: true}
+		if i := strings.Index(part, "!="); i != -1 {
+			c.column, c.value, c.equal = part[:i], part[i+2:], false
+		} else if i := strings.Index(part, "="); i != -1 {
+			c.column, c.value = part[:i], part[i+1:]
+		} else {
+			return nil, fmt.Errorf("bad condition: %q, want column=value "+
+				"or column!=value", part)
+		}
+		c.column, c.value = strings.TrimSpace(c.column),
+			strings.TrimSpace(c.value)
+		if c.column == "" {
+			return nil, fmt.Errorf("bad condition: %q, missing column name",
+				part)
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+// A sample that was excluded from the calculation.
+type exclusion struct {
+	id     string // Sample ID.
+	reason string // Why the sample was excluded.
+}
+
+// Removes samples according to the filtering arguments. Returns the remaining
+// samples and their IDs, and the excluded samples.
+func filterSamples(abnd []map[string]float64, ids []string,
+) ([]map[string]float64, []string, []exclusion, error) {
+	reasons := make([]string, len(abnd))
+	exclude := func(i int, format string, args ...any) {
+		if reasons[i] == "" {
+			reasons[i] = fmt.Sprintf(format, args...)
+		}
+	}
+
+	if *fincl != "" {
+		keep, err := readIDSet(*fincl)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for i, id := range ids {
+			if !keep[id] {
+				exclude(i, "not in include list")
+			}
+		}
+	}
+	if *fexcl != "" {
+		drop, err := readIDSet(*fexcl)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for i, id := range ids {
+			if drop[id] {
+				exclude(i, "in exclude list")
+			}
+		}
+	}
+	if *where != "" {
+		conds, _ := parseConditions(*where) // Checked in parseArgs.
+		meta, err := readMetadata()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, c := range conds {
+			col, err := meta.Column(c.column)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			for i, id := range ids {
+				val, ok := col[id]
+				if !ok {
+					exclude(i, "not in metadata")
+					continue
+				}
+				if (val == c.value) != c.equal {
+					exclude(i, "%s is %q", c.column, val)
+				}
+			}
+		}
+	}
+	for i, m := range abnd {
+		total, species := 0.0, 0
+		for _, v := range m {
+			total += v
+			if v > 0 {
+				species++
+			}
+		}
+		if total < *minTotal {
+			exclude(i, "total abundance %v is less than %v", total, *minTotal)
+		}
+		if species < *minSpecies {
+			exclude(i, "%d species are less than %d", species, *minSpecies)
+		}
+	}
+
+	var keptAbnd []map[string]float64
+	var keptIDs []string
+	var excluded []exclusion
+	for i := range abnd {
+		if reasons[i] != "" {
+			excluded = append(excluded, exclusion{ids[i], reasons[i]})
+			continue
+		}
+		keptAbnd = append(keptAbnd, abnd[i])
+		keptIDs = append(keptIDs, ids[i])
+	}
+	return keptAbnd, keptIDs, excluded, nil
+}
+
+// Returns whether any of the sample filtering arguments is set.
+func filteringSamples() bool {
+	return *fincl != "" || *fexcl != "" || *where != "" ||
+		*minTotal > 0 || *minSpecies > 0
+}
+
+// Reads a set of sample IDs from a file, one per line.
+func readIDSet(file string) (map[string]bool, error) {
+	f, err := aio.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	result := map[string]bool{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if id := strings.TrimSpace(sc.Text()); id != "" {
+			result[id] = true
+		}
+	}
+	return result, sc.Err()
+}
+
+// Writes the excluded samples as a tab-separated table.
+func writeExclusions(w io.Writer, excluded []exclusion) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "sample\treason")
+	for _, e := range excluded {
+		fmt.Fprintf(bw, "%s\t%s\n", e.id, e.reason)
+	}
+	return bw.Flush()
+}
+
+// Filters the samples according to the arguments, reporting the excluded
+// samples to stderr and to the report file. Returns the remaining samples
+// and their IDs.
+func applySampleFilters(abnd []map[string]float64, ids []string,
+) ([]map[string]float64, []string, error) {
+	fmt.Fprintln(os.Stderr, "Filtering samples")
+	abnd, ids, excluded, err := filterSamples(abnd, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Excluded %d samples, %d remain\n",
+		len(excluded), len(abnd))
+	if *filterOut != "" {
+		f, err := aio.Create(*filterOut)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := writeExclusions(f, excluded); err != nil {
+			return nil, nil, err
+		}
+		if err := f.Close(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(abnd) < 2 {
+		return nil, nil, fmt.Errorf("need at least 2 samples after filtering, "+
+			"have %d", len(abnd))
+	}
+	return abnd, ids, nil
+}
+
+// Summary statistics of a species that was removed from the samples.
+type removedSpecies struct {
+	name       string  // Species name.
+	prevalence float64 // Fraction of samples where the species is present.
+	mean       float64 // Mean relative abundance over all samples.
+}
+
+// Removes species from the samples according to the prevalence and mean
+// relative abundance arguments. Returns the removed species, and the
+// abundance that was removed from each sample.
+func filterSpecies(abnd []map[string]float64) ([]removedSpecies,
+	[]float64) {
+	present := map[string]int{}
+	sums := map[string]float64{} // Sums of relative abundances.
+	for _, m := range abnd {
+		total := 0.0
+		for _, v := range m {
+			total += v
+		}
+		for name, v := range m {
+			if v > 0 {
+				present[name]++
+				sums[name] += v / total
+			}
+		}
+	}
+
+	n := float64(len(abnd))
+	var removed []removedSpecies
+	drop := map[string]bool{}
+	for name, count := range present {
+		s := removedSpecies{name, float64(count) / n, sums[name] / n}
+		if s.prevalence*100 < *minPrevalence || s.mean < *minMeanAbnd {
+			removed = append(removed, s)
+			drop[name] = true
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].name < removed[j].name
+	})
+
+	mass := make([]float64, len(abnd))
+	for i, m := range abnd {
+		for name, v := range m {
+			if drop[name] {
+				mass[i] += v
+				delete(m, name)
+			}
+		}
+	}
+	return removed, mass
+}
+
+// Returns whether any of the species filtering arguments is set.
+func filteringSpecies() bool {
+	return *minPrevalence > 0 || *minMeanAbnd > 0
+}
+
+// Writes the removed species as a tab-separated table.
+func writeRemovedSpecies(w io.Writer, removed []removedSpecies) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "species\tprevalence\tmean_relative_abundance")
+	for _, s := range removed {
+		fmt.Fprintf(bw, "%s\t%v\t%v\n", s.name, s.prevalence, s.mean)
+	}
+	return bw.Flush()
+}
+
+// Writes the abundance that was removed from each sample as a tab-separated
+// table.
+func writeRemovedMass(w io.Writer, ids []string, mass []float64,
+	totals []float64) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "sample\tremoved_abundance\tremoved_fraction")
+	for i := range ids {
+		frac := 0.0
+		if totals[i] > 0 {
+			frac = mass[i] / totals[i]
+		}
+		fmt.Fprintf(bw, "%s\t%v\t%v\n", ids[i], mass[i], frac)
+	}
+	return bw.Flush()
+}
+
+// Removes the samples that have no species left. Returns the remaining
+// samples and their IDs, and the IDs of the removed samples.
+func dropEmptySamples(abnd []map[string]float64, ids []string,
+) ([]map[string]float64, []string, []string) {
+	var keptAbnd []map[string]float64
+	var keptIDs, dropped []string
+	for i, m := range abnd {
+		total := 0.0
+		for _, v := range m {
+			total += v
+		}
+		if total == 0 {
+			dropped = append(dropped, ids[i])
+			continue
+		}
+		keptAbnd = append(keptAbnd, m)
+		keptIDs = append(keptIDs, ids[i])
+	}
+	return keptAbnd, keptIDs, dropped
+}
+
+// Filters the species according to the arguments, reporting the removed
+// species and mass to stderr and to the report files. Samples that are left
+// with no species are removed with a warning. Returns the remaining samples
+// and their IDs.
+func applySpeciesFilters(abnd []map[string]float64, ids []string,
+) ([]map[string]float64, []string, error) {
+	fmt.Fprintln(os.Stderr, "Filtering species")
+	totals := make([]float64, len(abnd))
+	for i, m := range abnd {
+		for _, v := range m {
+			totals[i] += v
+		}
+	}
+	removed, mass := filterSpecies(abnd)
+	fmt.Fprintf(os.Stderr, "Removed %d species\n", len(removed))
+
+	if *speciesOut != "" {
+		f, err := aio.Create(*speciesOut)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := writeRemovedSpecies(f, removed); err != nil {
+			return nil, nil, err
+		}
+		if err := f.Close(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if *massOut != "" {
+		f, err := aio.Create(*massOut)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := writeRemovedMass(f, ids, mass, totals); err != nil {
+			return nil, nil, err
+		}
+		if err := f.Close(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	abnd, ids, dropped := dropEmptySamples(abnd, ids)
+	if len(dropped) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: removed %d samples with no species "+
+			"left: %s\n", len(dropped), strings.Join(dropped, ", "))
+	}
+	if len(abnd) < 2 {
+		return nil, nil, fmt.Errorf("need at least 2 samples after filtering "+
+			"species, have %d", len(abnd))
+	}
+	return abnd, ids, nil
+}
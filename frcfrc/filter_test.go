@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConditions(t *testing.T) {
+	got, err := parseConditions("site=gut, subject != a b,x=")
+	if err != nil {
+		t.Fatalf("parseConditions() failed: %v", err)
+	}
+	want := []condition{
+		{"site", "gut", true}, {"subject", "a b", false}, {"x", "", true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseConditions()=%v, want %v", got, want)
+	}
+	for _, input := range []string{"site", "=gut", "site=gut,"} {
+		if _, err := parseConditions(input); err == nil {
+			t.Errorf("parseConditions(%q) succeeded, want error", input)
+		}
+	}
+}
+
+func TestFilterSpecies(t *testing.T) {
+	defer func(p, m float64) {
+		*minPrevalence, *minMeanAbnd = p, m
+	}(*minPrevalence, *minMeanAbnd)
+	*minPrevalence, *minMeanAbnd = 50, 0.1
+
+	abnd := []map[string]float64{
+		{"a": 1, "b": 1, "c": 2},
+		{"a": 3, "c": 1},
+		{"a": 1, "d": 0.05},
+	}
+	removed, mass := filterSpecies(abnd)
+	wantRemoved := []removedSpecies{
+		{"b", 1.0 / 3, 0.25 / 3},
+		{"d", 1.0 / 3, 0.05 / 1.05 / 3},
+	}
+	if !reflect.DeepEqual(removed, wantRemoved) {
+		t.Errorf("filterSpecies()=%v, want %v", removed, wantRemoved)
+	}
+	if want := []float64{1, 0, 0.05}; !reflect.DeepEqual(mass, want) {
+		t.Errorf("filterSpecies() mass=%v, want %v", mass, want)
+	}
+	wantAbnd := []map[string]float64{
+		{"a": 1, "c": 2}, {"a": 3, "c": 1}, {"a": 1},
+	}
+	if !reflect.DeepEqual(abnd, wantAbnd) {
+		t.Errorf("filterSpecies() abundances=%v, want %v", abnd, wantAbnd)
+	}
+}
+
+func TestDropEmptySamples(t *testing.T) {
+	abnd := []map[string]float64{{"a": 1}, {}, {"b": 0}, {"b": 2}}
+	gotAbnd, gotIDs, gotDropped := dropEmptySamples(abnd,
+		[]string{"1", "2", "3", "4"})
+	wantAbnd := []map[string]float64{{"a": 1}, {"b": 2}}
+	if !reflect.DeepEqual(gotAbnd, wantAbnd) {
+		t.Errorf("dropEmptySamples()=%v, want %v", gotAbnd, wantAbnd)
+	}
+	if want := []string{"1", "4"}; !reflect.DeepEqual(gotIDs, want) {
+		t.Errorf("dropEmptySamples() ids=%v, want %v", gotIDs, want)
+	}
+	if want := []string{"2", "3"}; !reflect.DeepEqual(gotDropped, want) {
+		t.Errorf("dropEmptySamples() dropped=%v, want %v", gotDropped, want)
+	}
+}
+
+func TestWriteRemovedMass(t *testing.T) {
+	got := &strings.Builder{}
+	err := writeRemovedMass(got, []string{"1", "2"}, []float64{1, 0},
+		[]float64{4, 0})
+	if err != nil {
+		t.Fatalf("writeRemovedMass() failed: %v", err)
+	}
+	want := "sample\tremoved_abundance\tremoved_fraction\n" +
+		"1\t1\t0.25\n2\t0\t0\n"
+	if got.String() != want {
+		t.Errorf("writeRemovedMass()=%q, want %q", got.String(), want)
+	}
+}
@@ -2,65 +2,181 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"io"
+	"iter"
 	"os"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/fluhus/biostuff/formats/newick"
 	"github.com/fluhus/frackyfrac/common"
-	"github.com/fluhus/frackyfrac/parser"
+	"github.com/fluhus/frackyfrac/trees"
 	"github.com/fluhus/gostuff/aio"
 )
 
 var (
-	fin    = flag.String("i", "", "Path to input file (default stdin)")
-	fout   = flag.String("o", "", "Path to output file (default stdout)")
-	ftree  = flag.String("t", "", "Path to tree file, required")
+	fin   = flag.String("i", "", "Path to input file (default stdin)")
+	fout  = flag.String("o", "", "Path to output file (default stdout)")
+	ftree = flag.String("t", "", "Path to tree file in newick, NEXUS, "+
+		"PhyloXML or jplace format, required")
 	wgt    = flag.Bool("w", false, "Use weighted UniFrac (default unweighted)")
 	sparse = flag.Bool("s", false, "Input is in sparse format")
 	nt     = flag.Int("p", 1, "Number of threads")
 	nnorm  = flag.Bool("l", false, "Leave abundance values unnormalized "+
 		"(default normalize each sample to sum up to 1)")
+	xpair = flag.String("x", "", "Instead of all distances, output each "+
+		"node's contribution to the distance between the given pair "+
+		"of 1-based sample numbers (e.g. 3,7)")
+	xtree = flag.String("xt", "", "Path to output tree annotated with "+
+		"node contributions, for use with -x")
+	prune = flag.Bool("prune", false, "Prune the tree to the species that "+
+		"appear in the input, to save time and memory")
+	pruneOut = flag.String("prune-out", "", "Path to output pruned tree, "+
+		"for use with -prune")
+	root = flag.String("root", "", "Reroot the tree: midpoint, outgroup "+
+		"or mad (minimal ancestor deviation) (default keep the top node "+
+		"as the root)")
+	outgroup = flag.String("outgroup", "", "Comma-separated names of the "+
+		"outgroup leaves, for use with -root outgroup")
+	multi = flag.String("trees", "", "Use all the trees in the tree file: "+
+		"each (one output file per tree, numbered before the output's "+
+		"extension, like out.1.tsv, out.2.tsv...) or "+
+		"summary (mean and standard deviation of each distance) "+
+		"(default use the first tree)")
+	placement = flag.String("placement", "best", "How to graft the queries "+
+		"of a jplace tree: best (at the placement with the highest "+
+		"likelihood weight) or weighted (at all placements, splitting the "+
+		"abundances by likelihood weight)")
+	graftOut = flag.String("graft-out", "", "Path to output tree with the "+
+		"grafted queries, for use with a jplace tree")
+	fmeta = flag.String("m", "", "Path to tab-separated metadata file, "+
+		"with sample IDs (1-based row numbers, or file names without "+
+		"extension with -f) in the first column")
+	groupBy = flag.String("group-by", "", "Name of a metadata column; "+
+		"writes the distances within each group to OUTPUT.<group> and the "+
+		"mean distances between groups to OUTPUT.summary")
+	minTotal = flag.Float64("min-total", 0, "Exclude samples whose total "+
+		"abundance is less than this value")
+	minSpecies = flag.Int("min-species", 0, "Exclude samples with less "+
+		"than this number of observed species")
+	fincl = flag.String("include", "", "Path to file with the IDs of the "+
+		"samples to include, one per line")
+	fexcl = flag.String("exclude", "", "Path to file with the IDs of the "+
+		"samples to exclude, one per line")
+	where = flag.String("where", "", "Include only samples whose metadata "+
+		"match the given comma-separated conditions, of the form "+
+		"column=value or column!=value, for use with -m")
+	filterOut = flag.String("filter-out", "", "Path to output report of the "+
+		"excluded samples and the reasons for their exclusion")
+	minPrevalence = flag.Float64("min-prevalence", 0, "Remove species that "+
+		"are present in less than this percentage of the samples (0-100)")
+	minMeanAbnd = flag.Float64("min-mean-abundance", 0, "Remove species "+
+		"whose mean relative abundance over the samples is less than this "+
+		"value (0-1)")
+	speciesOut = flag.String("species-out", "", "Path to output report of "+
+		"the removed species, for use with species filters")
+	massOut = flag.String("mass-out", "", "Path to output report of the "+
+		"abundance that was removed from each sample, for use with species "+
+		"filters")
+	transform = flag.String("transform", "", "Transform the abundances "+
+		"before calculating distances: rarefy (subsample each sample to the "+
+		"same depth), sqrt (Hellinger, square root of proportions) or log1p "+
+		"(log(1+x)) (default none)")
+	rareDepth = flag.Int("rarefy-depth", 0, "Depth to rarefy to, samples "+
+		"with less counts are dropped (default the smallest sample's total)")
+	seed  = flag.Uint64("seed", 0, "Random seed for rarefaction")
+	fcopy = flag.String("copy-number", "", "Path to tab-separated file "+
+		"with species names and factors to divide their abundances by, "+
+		"such as 16S copy numbers or genome sizes")
+	format = flag.String("f", "", "Read one sample per input file instead "+
+		"of a table: tsv (species and abundance columns), bracken "+
+		"(estimated reads), kraken2 (species clade reads) or sourmash "+
+		"(gather's weighted fractions); input files, "+
+		"directories or glob patterns are given as positional arguments")
+	idsOut = flag.String("ids-out", "", "Path to output the IDs of the "+
+		"samples in the output distances, one per line")
 )
 
+// Samples to explain, parsed from -x.
+var xi, xj int
+
+// Leaves that each query of a jplace tree was grafted as.
+var placementSplit map[string][]trees.Fraction
+
 func main() {
 	common.ExitIfError(parseArgs())
 	debug.SetGCPercent(20) // Make the garbage collector more eager.
 
 	t := time.Now()
-	fmt.Fprintln(os.Stderr, "Reading tree")
-	tree, err := readTree()
-	common.ExitIfError(err)
+	var tree *newick.Node
+	var err error
+	if *multi == "" {
+		fmt.Fprintln(os.Stderr, "Reading tree")
+		tree, err = readTree()
+		common.ExitIfError(err)
+	}
 
 	fmt.Fprintln(os.Stderr, "Loading abundances")
-	r, err := openInput()
+	abnd, ids, err := loadAbundances()
 	common.ExitIfError(err)
-	var abnd []map[string]float64
-	if *sparse {
-		err = parser.ParseSparseAbundance(r, *nt, func(m map[string]float64) {
-			abnd = append(abnd, m)
-		})
-	} else {
-		err = parser.ParseAbundance(r, *nt, func(m map[string]float64) {
-			abnd = append(abnd, m)
-		})
+	allIDs := ids
+	if placementSplit != nil {
+		splitPlacements(abnd, placementSplit)
+	}
+	if filteringSamples() {
+		abnd, ids, err = applySampleFilters(abnd, ids)
+		common.ExitIfError(err)
+	}
+	if filteringSpecies() {
+		abnd, ids, err = applySpeciesFilters(abnd, ids)
+		common.ExitIfError(err)
+	}
+	if *transform != "" || *fcopy != "" {
+		abnd, ids, err = applyTransforms(abnd, ids)
+		common.ExitIfError(err)
+	}
+	if *idsOut != "" {
+		common.ExitIfError(writeIDs(ids))
+	}
+
+	if *multi != "" {
+		common.ExitIfError(unifracAllTrees(abnd))
+		fmt.Fprintln(os.Stderr, "Took", time.Since(t))
+		fmt.Fprintln(os.Stderr, "Done")
+		return
 	}
-	common.ExitIfError(err)
 
 	fmt.Fprintln(os.Stderr, "Validating")
 	common.ExitIfError(validateSpecies(abnd, tree))
 
-	w, err := openOutput()
+	tree, norms, err := prepareTree(tree, abnd)
 	common.ExitIfError(err)
-	for f := range unifrac(abnd, tree, *wgt) {
-		if _, err = fmt.Fprintln(w, f); err != nil {
-			break
-		}
+
+	if *groupBy != "" {
+		common.ExitIfError(unifracByGroup(abnd, ids, tree, norms))
+		fmt.Fprintln(os.Stderr, "Took", time.Since(t))
+		fmt.Fprintln(os.Stderr, "Done")
+		return
 	}
+
+	w, err := openOutput()
 	common.ExitIfError(err)
+	if *xpair != "" {
+		fmt.Fprintln(os.Stderr, "Explaining distance")
+		i, err := sampleIndex(ids, allIDs, xi)
+		common.ExitIfError(err)
+		j, err := sampleIndex(ids, allIDs, xj)
+		common.ExitIfError(err)
+		common.ExitIfError(explain(abnd, tree, norms, i, j, *wgt, w, *xtree))
+		common.ExitIfError(w.Close())
+		fmt.Fprintln(os.Stderr, "Done")
+		return
+	}
+	common.ExitIfError(writeDistances(w, unifrac(abnd, tree, norms, *wgt)))
 	w.Close()
 	fmt.Fprintln(os.Stderr, "Took", time.Since(t))
 	fmt.Fprintln(os.Stderr, "Done")
@@ -81,9 +197,142 @@ func parseArgs() error {
 	if *nt < 1 {
 		return fmt.Errorf("bad number of threads: %d", *nt)
 	}
+	if *format != "" {
+		if fileParsers[*format] == nil {
+			return fmt.Errorf("unknown input format: %q", *format)
+		}
+		if *fin != "" || *sparse {
+			return fmt.Errorf("-i and -s cannot be used with -f")
+		}
+		if flag.NArg() == 0 {
+			return fmt.Errorf("please provide input files with -f")
+		}
+	} else if flag.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v, input files can only "+
+			"be used with -f", flag.Args())
+	}
 	if *nnorm && !*wgt {
 		return fmt.Errorf("-l can only be used with weighted unifrac")
 	}
+	if *xpair != "" {
+		var err error
+		xi, xj, err = parseExplainPair(*xpair)
+		if err != nil {
+			return err
+		}
+	} else if *xtree != "" {
+		return fmt.Errorf("-xt can only be used with -x")
+	}
+	if *pruneOut != "" && !*prune {
+		return fmt.Errorf("-prune-out can only be used with -prune")
+	}
+	switch *multi {
+	case "":
+	case "each", "summary":
+		if *multi == "each" && *fout == "" {
+			return fmt.Errorf("-trees each requires an output file with -o")
+		}
+		if *xpair != "" {
+			return fmt.Errorf("-x cannot be used with -trees")
+		}
+		if *pruneOut != "" {
+			return fmt.Errorf("-prune-out cannot be used with -trees")
+		}
+	default:
+		return fmt.Errorf("unknown -trees mode: %q", *multi)
+	}
+	switch *root {
+	case "", "midpoint", "mad":
+		if *outgroup != "" {
+			return fmt.Errorf("-outgroup can only be used with -root outgroup")
+		}
+	case "outgroup":
+		if *outgroup == "" {
+			return fmt.Errorf("please provide outgroup leaves with -outgroup")
+		}
+	default:
+		return fmt.Errorf("unknown rooting method: %q", *root)
+	}
+	if *groupBy != "" {
+		if *fmeta == "" {
+			return fmt.Errorf("-group-by requires a metadata file with -m")
+		}
+		if *fout == "" {
+			return fmt.Errorf("-group-by requires an output file with -o")
+		}
+		if *xpair != "" {
+			return fmt.Errorf("-x cannot be used with -group-by")
+		}
+		if *multi != "" {
+			return fmt.Errorf("-trees cannot be used with -group-by")
+		}
+	} else if *fmeta != "" && *where == "" {
+		return fmt.Errorf("-m can only be used with -group-by or -where")
+	}
+	if *where != "" {
+		if *fmeta == "" {
+			return fmt.Errorf("-where requires a metadata file with -m")
+		}
+		if _, err := parseConditions(*where); err != nil {
+			return err
+		}
+	}
+	if *filterOut != "" && !filteringSamples() {
+		return fmt.Errorf("-filter-out can only be used with sample filters")
+	}
+	if *minPrevalence < 0 || *minPrevalence > 100 {
+		return fmt.Errorf("bad minimal prevalence: %v, want 0-100",
+			*minPrevalence)
+	}
+	if *minMeanAbnd < 0 || *minMeanAbnd > 1 {
+		return fmt.Errorf("bad minimal mean abundance: %v, want 0-1",
+			*minMeanAbnd)
+	}
+	switch *transform {
+	case "", "rarefy":
+	case "sqrt", "log1p":
+		if !*wgt {
+			return fmt.Errorf("-transform %s can only be used with weighted "+
+				"unifrac", *transform)
+		}
+	default:
+		return fmt.Errorf("unknown transform: %q", *transform)
+	}
+	if *rareDepth < 0 {
+		return fmt.Errorf("bad rarefaction depth: %d", *rareDepth)
+	}
+	if *rareDepth > 0 && *transform != "rarefy" {
+		return fmt.Errorf("-rarefy-depth can only be used with " +
+			"-transform rarefy")
+	}
+	if *fcopy != "" && !*wgt {
+		return fmt.Errorf("-copy-number can only be used with weighted unifrac")
+	}
+	if (*speciesOut != "" || *massOut != "") && !filteringSpecies() {
+		return fmt.Errorf("-species-out and -mass-out can only be used with " +
+			"species filters")
+	}
+	isJPlace := trees.FileFormat(*ftree) == trees.JPlace
+	switch *placement {
+	case "best":
+	case "weighted":
+		if !isJPlace {
+			return fmt.Errorf("-placement can only be used with a jplace tree")
+		}
+		if *multi != "" {
+			return fmt.Errorf("-placement weighted cannot be used with -trees")
+		}
+	default:
+		return fmt.Errorf("unknown placement mode: %q", *placement)
+	}
+	if *graftOut != "" {
+		if !isJPlace {
+			return fmt.Errorf("-graft-out can only be used with a jplace tree")
+		}
+		if *multi != "" {
+			return fmt.Errorf("-graft-out cannot be used with -trees")
+		}
+	}
 	return nil
 }
 
@@ -105,14 +354,122 @@ func openOutput() (io.WriteCloser, error) {
 	}
 }
 
-// Reads the tree from the path in the argument.
+// Reads the first tree from the path in the argument. Queries of a jplace tree
+// are grafted onto it according to -placement.
 func readTree() (*newick.Node, error) {
-	for t, err := range newick.File(*ftree) {
+	if trees.FileFormat(*ftree) == trees.JPlace {
+		tree, split, err := readPlacements()
+		placementSplit = split
+		return tree, err
+	}
+	for t, err := range iterTrees() {
 		return t, err
 	}
 	return nil, fmt.Errorf("no tree in the given file")
 }
 
+// Iterates over the trees in the path in the argument.
+func iterTrees() iter.Seq2[*newick.Node, error] {
+	return trees.File(*ftree)
+}
+
+// Writes the sample IDs to the path in the argument, one per line.
+func writeIDs(ids []string) error {
+	f, err := aio.Create(*idsOut)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	for _, id := range ids {
+		fmt.Fprintln(bw, id)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Writes the given distances, one per line.
+func writeDistances(w io.Writer, dists iter.Seq[float64]) error {
+	bw := bufio.NewWriter(w)
+	for f := range dists {
+		if _, err := fmt.Fprintln(bw, f); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Reroots and prunes the tree according to the arguments. When pruning, also
+// returns the samples' normalizers on the full tree, so that normalized
+// distances on the pruned tree are the same.
+func prepareTree(tree *newick.Node, abnd []map[string]float64,
+) (*newick.Node, []float64, error) {
+	var err error
+	switch *root {
+	case "":
+		if trees.IsUnrooted(tree) {
+			fmt.Fprintf(os.Stderr, "WARNING: the tree looks unrooted "+
+				"(top node has %d children), "+
+				"consider rerooting it with -root\n", len(tree.Children))
+		}
+	case "midpoint":
+		fmt.Fprintln(os.Stderr, "Rooting tree at midpoint")
+		tree, err = trees.MidpointRoot(tree)
+	case "outgroup":
+		fmt.Fprintln(os.Stderr, "Rooting tree with outgroup")
+		tree, err = trees.OutgroupRoot(tree, strings.Split(*outgroup, ","))
+	case "mad":
+		fmt.Fprintln(os.Stderr, "Rooting tree with MAD")
+		tree, err = trees.MADRoot(tree)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var norms []float64
+	if *prune {
+		if !*nnorm {
+			norms = flatNodeSums(abnd, tree)
+		}
+		fmt.Fprintln(os.Stderr, "Pruning tree")
+		tree, err = pruneTree(abnd, tree)
+		if err != nil {
+			return nil, nil, err
+		}
+		if *pruneOut != "" {
+			treeText, _ := tree.MarshalText()
+			if err := os.WriteFile(*pruneOut, treeText, 0o644); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return tree, norms, nil
+}
+
+// Returns the tree pruned to the species that appear in the given
+// abundances.
+func pruneTree(abnd []map[string]float64, tree *newick.Node,
+) (*newick.Node, error) {
+	species := map[string]struct{}{}
+	for _, m := range abnd {
+		for name := range m {
+			species[name] = struct{}{}
+		}
+	}
+	before := trees.Leaves(tree)
+	tree = trees.Prune(tree, func(n *newick.Node) bool {
+		_, ok := species[n.Name]
+		return ok
+	})
+	if tree == nil {
+		return nil, fmt.Errorf("no species from the input are in the tree")
+	}
+	fmt.Fprintf(os.Stderr, "Kept %d out of %d leaves\n",
+		trees.Leaves(tree), before)
+	return tree, nil
+}
+
 // Prints usage help message.
 func usage() {
 	fmt.Fprintln(flag.CommandLine.Output(), usageMessage)
@@ -122,4 +479,8 @@ func usage() {
 const usageMessage = `FrackyFrac calculates UniFrac on the given abundance table.
 Outputs one distance per line in the order (1,2),(1,3),(2,3)...(1,n)...(n-1,n).
 
+Usage:
+frcfrc [PARAMS]
+frcfrc -f FORMAT [PARAMS] sample1.txt sample2.txt ...
+
 Params:`
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fluhus/biostuff/formats/newick"
+	"github.com/fluhus/frackyfrac/parser"
+	"github.com/fluhus/gostuff/aio"
+)
+
+// Returns the IDs of the n input samples, which are their 1-based row
+// numbers.
+func sampleIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i + 1)
+	}
+	return ids
+}
+
+// Reads the metadata file in the arguments.
+func readMetadata() (*parser.Metadata, error) {
+	f, err := aio.Open(*fmeta)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parser.ParseMetadata(f)
+}
+
+// Calculates the distances and writes the distances within each group to
+// OUTPUT.<group>, and the mean distance between each pair of groups to
+// OUTPUT.summary. All distances are calculated in a single pass, with the
+// samples normalized by norms if it is not nil.
+func unifracByGroup(abnd []map[string]float64, ids []string,
+	tree *newick.Node, norms []float64) error {
+	meta, err := readMetadata()
+	if err != nil {
+		return err
+	}
+	groups, names, err := meta.Groups(*groupBy, ids)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Found", len(names), "groups")
+	fileNames, err := groupFileNames(names)
+	if err != nil {
+		return err
+	}
+
+	files := make([]io.WriteCloser, len(names))
+	defer func() { // Closes the files that are left open on errors.
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	writers := make([]*bufio.Writer, len(names))
+	for g, name := range fileNames {
+		f, err := aio.Create(*fout + "." + name)
+		if err != nil {
+			return err
+		}
+		files[g] = f
+		writers[g] = bufio.NewWriter(f)
+	}
+
+	sums := make([][]float64, len(names)) // Lower triangle, by group pair.
+	counts := make([][]int, len(names))
+	for g := range names {
+		sums[g] = make([]float64, g+1)
+		counts[g] = make([]int, g+1)
+	}
+	i, j := 1, 0
+	for d := range unifrac(abnd, tree, norms, *wgt) {
+		gi, gj := groups[i], groups[j]
+		if gi == gj {
+			if _, err := fmt.Fprintln(writers[gi], d); err != nil {
+				return err
+			}
+		}
+		gi, gj = max(gi, gj), min(gi, gj)
+		sums[gi][gj] += d
+		counts[gi][gj]++
+		if j++; j == i {
+			i++
+			j = 0
+		}
+	}
+	for g := range names {
+		if err := writers[g].Flush(); err != nil {
+			return err
+		}
+		f := files[g]
+		files[g] = nil
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	f, err := aio.Create(*fout + "." + groupSummaryName)
+	if err != nil {
+		return err
+	}
+	if err := writeGroupSummary(f, names, sums, counts); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Writes the mean distance within and between the groups, as a tab-separated
+// table. Groups with no pairs get NaN.
+func writeGroupSummary(w io.Writer, names []string, sums [][]float64,
+	counts [][]int) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "group_1\tgroup_2\tpairs\tmean_distance")
+	for gi := range names {
+		for gj := range gi + 1 {
+			fmt.Fprintf(bw, "%s\t%s\t%d\t%v\n", names[gj], names[gi],
+				counts[gi][gj], sums[gi][gj]/float64(counts[gi][gj]))
+		}
+	}
+	return bw.Flush()
+}
+
+// Suffix of the output file with the group summary.
+const groupSummaryName = "summary"
+
+// Returns a version of the group name that can be used in a file name.
+func groupFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// Returns the file name of each group. Returns an error if groups have the
+// same file name, or one that is reserved for the summary.
+func groupFileNames(names []string) ([]string, error) {
+	result := make([]string, len(names))
+	groups := map[string]string{} // Group by file name.
+	for i, name := range names {
+		result[i] = groupFileName(name)
+		if result[i] == groupSummaryName {
+			return nil, fmt.Errorf("group %q would overwrite the summary "+
+				"file, please rename it", name)
+		}
+		if other, ok := groups[result[i]]; ok {
+			return nil, fmt.Errorf("groups %q and %q would be written to "+
+				"the same file, please rename one of them", other, name)
+		}
+		groups[result[i]] = name
+	}
+	return result, nil
+}
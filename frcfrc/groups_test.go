@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUniFracByGroup(t *testing.T) {
+	defer func(fmeta0, groupBy0, fout0 string, wgt0 bool) {
+		*fmeta, *groupBy, *fout, *wgt = fmeta0, groupBy0, fout0, wgt0
+	}(*fmeta, *groupBy, *fout, *wgt)
+	dir := t.TempDir()
+	*fmeta = filepath.Join(dir, "meta.tsv")
+	*groupBy = "site"
+	*fout = filepath.Join(dir, "out")
+	*wgt = false
+	meta := "id\tsite\n1\tgut\n2\toral/nasal\n3\tgut\n"
+	if err := os.WriteFile(*fmeta, []byte(meta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := parseTree("(s2:3,s1:1,s3:5);")
+	if err != nil {
+		t.Fatal("failed to parse tree:", err)
+	}
+	abnd := []map[string]float64{
+		{"s1": 1, "s2": 1},
+		{"s3": 1, "s2": 1},
+		{"s1": 1},
+	}
+	// Distances: (1,2)=6/9, (1,3)=3/4, (2,3)=1.
+	if err := unifracByGroup(abnd, []string{"1", "2", "3"}, tree, nil); err != nil {
+		t.Fatalf("unifracByGroup() failed: %v", err)
+	}
+	want := map[string]string{
+		"out.gut":        "0.75\n",
+		"out.oral_nasal": "",
+		"out.summary": "group_1\tgroup_2\tpairs\tmean_distance\n" +
+			"gut\tgut\t1\t0.75\n" +
+			"gut\toral/nasal\t2\t0.8333333333333333\n" +
+			"oral/nasal\toral/nasal\t0\tNaN\n",
+	}
+	for name, want := range want {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", name, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s=%q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestWriteGroupSummary(t *testing.T) {
+	names := []string{"a", "b"}
+	sums := [][]float64{{1}, {3, 0}}
+	counts := [][]int{{2}, {4, 0}}
+	got := &strings.Builder{}
+	if err := writeGroupSummary(got, names, sums, counts); err != nil {
+		t.Fatalf("writeGroupSummary() failed: %v", err)
+	}
+	want := "group_1\tgroup_2\tpairs\tmean_distance\n" +
+		"a\ta\t2\t0.5\n" +
+		"a\tb\t4\t0.75\n" +
+		"b\tb\t0\tNaN\n"
+	if got.String() != want {
+		t.Errorf("writeGroupSummary()=%q, want %q", got.String(), want)
+	}
+}
+
+func TestGroupFileNames(t *testing.T) {
+	got, err := groupFileNames([]string{"a/b", "c\\d", "e f"})
+	if err != nil {
+		t.Fatalf("groupFileNames() failed: %v", err)
+	}
+	if want := []string{"a_b", "c_d", "e f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("groupFileNames()=%v, want %v", got, want)
+	}
+	for _, names := range [][]string{
+		{"a/b", "a_b"},
+		{"x", "summary"},
+	} {
+		if got, err := groupFileNames(names); err == nil {
+			t.Errorf("groupFileNames(%q)=%v, want error", names, got)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/fluhus/frackyfrac/parser"
+	"github.com/fluhus/gostuff/aio"
+	"github.com/fluhus/gostuff/ppln"
+)
+
+// Parsers of single-sample input files, by format name.
+var fileParsers = map[string]func(io.Reader) (map[string]float64, error){
+	"tsv":     parser.ParseTwoColumns,
+	"bracken": parser.ParseBracken,
+	"kraken2": func(r io.Reader) (map[string]float64, error) {
+		return parser.ParseKraken2(r, "S")
+	},
+	"sourmash": parser.ParseSourmashGather,
+}
+
+// Loads the input abundances and returns them with their sample IDs.
+func loadAbundances() ([]map[string]float64, []string, error) {
+	if *format != "" {
+		files, err := expandInputs(flag.Args())
+		if err != nil {
+			return nil, nil, err
+		}
+		return readSampleFiles(files, fileParsers[*format])
+	}
+
+	r, err := openInput()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+	var abnd []map[string]float64
+	if *sparse {
+		err = parser.ParseSparseAbundance(r, *nt, func(m map[string]float64) {
+			abnd = append(abnd, m)
+		})
+	} else {
+		err = parser.ParseAbundance(r, *nt, func(m map[string]float64) {
+			abnd = append(abnd, m)
+		})
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return abnd, sampleIDs(len(abnd)), nil
+}
+
+// Expands the given paths to input files. Directories are replaced with the
+// files in them and glob patterns with their matches. Files that are matched
+// more than once are kept at their first match.
+func expandInputs(paths []string) ([]string, error) {
+	var result []string
+	seen := map[string]bool{}
+	add := func(file string) {
+		if clean := filepath.Clean(file); !seen[clean] {
+			seen[clean] = true
+			result = append(result, file)
+		}
+	}
+	for _, pat := range paths {
+		matches, err := filepath.Glob(pat)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", pat)
+		}
+		for _, path := range matches {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(path)
+				continue
+			}
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			var files []string
+			for _, e := range entries {
+				if !e.IsDir() {
+					files = append(files, filepath.Join(path, e.Name()))
+				}
+			}
+			sort.Strings(files)
+			for _, file := range files {
+				add(file)
+			}
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no input files")
+	}
+	return result, nil
+}
+
+// Reads one sample from each of the given files in parallel, with the given
+// parser. Returns the abundances and the sample IDs, which are taken from the
+// file names.
+func readSampleFiles(files []string,
+	parse func(io.Reader) (map[string]float64, error),
+) ([]map[string]float64, []string, error) {
+	ids := make([]string, len(files))
+	seen := map[string]string{}
+	for i, file := range files {
+		ids[i] = parser.SampleID(file)
+		if other, ok := seen[ids[i]]; ok {
+			return nil, nil, fmt.Errorf("files %q and %q have the same "+
+				"sample ID: %q", other, file, ids[i])
+		}
+		seen[ids[i]] = file
+	}
+
+	abnd := make([]map[string]float64, 0, len(files))
+	err := ppln.Serial(*nt,
+		ppln.SliceInput(files),
+		func(file string, _, _ int) (map[string]float64, error) {
+			f, err := aio.Open(file)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			m, err := parse(f)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			return m, nil
+		},
+		func(m map[string]float64) error {
+			abnd = append(abnd, m)
+			return nil
+		})
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Fprintln(os.Stderr, "Read", len(files), "samples")
+	return abnd, ids, nil
+}
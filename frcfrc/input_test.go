@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.tsv", "b.tsv", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := expandInputs([]string{
+		filepath.Join(dir, "b.tsv"),
+		filepath.Join(dir, "*.tsv"),
+		dir,
+	})
+	if err != nil {
+		t.Fatalf("expandInputs() failed: %v", err)
+	}
+	want := []string{
+		filepath.Join(dir, "b.tsv"),
+		filepath.Join(dir, "a.tsv"),
+		filepath.Join(dir, "c.txt"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandInputs()=%v, want %v", got, want)
+	}
+	if got, err := expandInputs([]string{filepath.Join(dir, "*.csv")}); err == nil {
+		t.Errorf("expandInputs(*.csv)=%v, want error", got)
+	}
+}
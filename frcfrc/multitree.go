@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fluhus/gostuff/aio"
+)
+
+// Calculates the distances over every tree in the tree file. Writes either
+// one output file per tree or a summary of all trees, according to -trees.
+func unifracAllTrees(abnd []map[string]float64) error {
+	var sum *distSummary
+	if *multi == "summary" {
+		sum = newDistSummary(len(abnd) * (len(abnd) - 1) / 2)
+	}
+	i := 0
+	for tree, err := range iterTrees() {
+		if err != nil {
+			return err
+		}
+		i++
+		fmt.Fprintf(os.Stderr, "Tree #%d\n", i)
+		if err := validateSpecies(abnd, tree); err != nil {
+			return fmt.Errorf("tree #%d: %v", i, err)
+		}
+		tree, norms, err := prepareTree(tree, abnd)
+		if err != nil {
+			return fmt.Errorf("tree #%d: %v", i, err)
+		}
+		dists := unifrac(abnd, tree, norms, *wgt)
+
+		if sum != nil {
+			sum.add(dists)
+			continue
+		}
+		w, err := aio.Create(outputPath(*fout, fmt.Sprint(i)))
+		if err != nil {
+			return err
+		}
+		if err := writeDistances(w, dists); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	if i == 0 {
+		return fmt.Errorf("no tree in the given file")
+	}
+	fmt.Fprintln(os.Stderr, "Processed", i, "trees")
+	if sum == nil {
+		return nil
+	}
+
+	w, err := openOutput()
+	if err != nil {
+		return err
+	}
+	if err := sum.write(w); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// Returns the output path with the given part inserted before its extension,
+// like out.1.tsv.gz for out.tsv.gz, so that the compression by extension is
+// kept.
+func outputPath(out, part string) string {
+	compression := ""
+	switch ext := filepath.Ext(out); ext {
+	case ".gz", ".bz2", ".zst":
+		out, compression = strings.TrimSuffix(out, ext), ext
+	}
+	ext := filepath.Ext(out)
+	return strings.TrimSuffix(out, ext) + "." + part + ext + compression
+}
+
+// Accumulates the mean and variance of each distance over multiple
+// matrices, using Welford's algorithm.
+type distSummary struct {
+	n    int       // Number of matrices.
+	mean []float64 // Running mean of each distance.
+	m2   []float64 // Running sum of squared differences from the mean.
+}
+
+// Returns an empty summary of matrices with the given number of distances.
+func newDistSummary(npairs int) *distSummary {
+	return &distSummary{
+		mean: make([]float64, npairs),
+		m2:   make([]float64, npairs),
+	}
+}
+
+// Adds a matrix to the summary.
+func (s *distSummary) add(dists iter.Seq[float64]) {
+	s.n++
+	i := 0
+	for d := range dists {
+		delta := d - s.mean[i]
+		s.mean[i] += delta / float64(s.n)
+		s.m2[i] += delta * (d - s.mean[i])
+		i++
+	}
+}
+
+// Returns the mean and sample standard deviation of distance i.
+func (s *distSummary) get(i int) (float64, float64) {
+	if s.n < 2 {
+		return s.mean[i], 0
+	}
+	return s.mean[i], math.Sqrt(s.m2[i] / float64(s.n-1))
+}
+
+// Writes the mean and standard deviation of each distance, tab-separated,
+// one distance per line.
+func (s *distSummary) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for i := range s.mean {
+		mean, std := s.get(i)
+		if _, err := fmt.Fprintf(bw, "%v\t%v\n", mean, std); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestDistSummary(t *testing.T) {
+	matrices := [][]float64{{1, 0.5, 0}, {3, 0.5, 0.2}, {2, 0.5, 0.4}}
+	wantMean := []float64{2, 0.5, 0.2}
+	wantStd := []float64{1, 0, 0.2}
+	s := newDistSummary(3)
+	for _, m := range matrices {
+		s.add(slices.Values(m))
+	}
+	for i := range wantMean {
+		mean, std := s.get(i)
+		if math.Abs(mean-wantMean[i]) > 1e-12 ||
+			math.Abs(std-wantStd[i]) > 1e-12 {
+			t.Errorf("get(%d)=%v,%v, want %v,%v",
+				i, mean, std, wantMean[i], wantStd[i])
+		}
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	tests := []struct {
+		out, want string
+	}{
+		{"out", "out.1"},
+		{"out.tsv", "out.1.tsv"},
+		{"out.tsv.gz", "out.1.tsv.gz"},
+		{"out.gz", "out.1.gz"},
+		{"dir.d/out", "dir.d/out.1"},
+	}
+	for _, test := range tests {
+		if got := outputPath(test.out, "1"); got != test.want {
+			t.Errorf("outputPath(%q, \"1\")=%q, want %q", test.out, got,
+				test.want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fluhus/biostuff/formats/newick"
+	"github.com/fluhus/frackyfrac/trees"
+)
+
+// Reads the jplace file in the tree argument and returns the reference tree
+// with the queries grafted onto it. Also returns the leaves that each query
+// was grafted as.
+func readPlacements() (*newick.Node, map[string][]trees.Fraction, error) {
+	j, err := trees.ReadJPlaceFile(*ftree)
+	if err != nil {
+		return nil, nil, err
+	}
+	tree, split := j.Graft(*placement == "weighted")
+	fmt.Fprintf(os.Stderr, "Grafted %d queries\n", len(j.Queries))
+	if *graftOut != "" {
+		treeText, _ := tree.MarshalText()
+		if err := os.WriteFile(*graftOut, treeText, 0o644); err != nil {
+			return nil, nil, err
+		}
+	}
+	return tree, split, nil
+}
+
+// Spreads the abundances of grafted queries over the leaves they were grafted
+// as.
+func splitPlacements(abnd []map[string]float64,
+	split map[string][]trees.Fraction) {
+	for _, m := range abnd {
+		var names []string
+		for name := range m {
+			fracs := split[name]
+			if len(fracs) == 0 || (len(fracs) == 1 && fracs[0].Name == name) {
+				continue
+			}
+			names = append(names, name)
+		}
+		for _, name := range names {
+			a := m[name]
+			delete(m, name)
+			for _, f := range split[name] {
+				m[f.Name] += a * f.Weight
+			}
+		}
+	}
+}
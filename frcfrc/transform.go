@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fluhus/gostuff/aio"
+)
+
+// Applies the abundance transforms in the arguments. Returns the remaining
+// samples and their IDs, since rarefaction drops samples that are too small.
+func applyTransforms(abnd []map[string]float64, ids []string,
+) ([]map[string]float64, []string, error) {
+	if *transform == "rarefy" {
+		var err error
+		abnd, ids, err = rarefySamples(abnd, ids)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if *fcopy != "" {
+		fmt.Fprintln(os.Stderr, "Dividing by copy numbers")
+		factors, err := readCopyNumbers(*fcopy)
+		if err != nil {
+			return nil, nil, err
+		}
+		missing := divideByCopyNumbers(abnd, factors)
+		if missing > 0 {
+			fmt.Fprintf(os.Stderr, "WARNING: %d species have no copy number, "+
+				"leaving them as is\n", missing)
+		}
+	}
+	switch *transform {
+	case "sqrt":
+		fmt.Fprintln(os.Stderr, "Applying Hellinger transform")
+		for _, m := range abnd {
+			hellinger(m)
+		}
+	case "log1p":
+		fmt.Fprintln(os.Stderr, "Applying log1p transform")
+		for _, m := range abnd {
+			for k, v := range m {
+				m[k] = math.Log1p(v)
+			}
+		}
+	}
+	return abnd, ids, nil
+}
+
+// Replaces each abundance with the square root of its proportion in the
+// sample.
+func hellinger(m map[string]float64) {
+	total := 0.0
+	for _, v := range m {
+		total += v
+	}
+	for k, v := range m {
+		m[k] = math.Sqrt(v / total)
+	}
+}
+
+// Rarefies the samples to the depth in the arguments, or to the smallest
+// sample's total if not given. Samples whose total is below the depth are
+// dropped. Returns the remaining samples and their IDs.
+func rarefySamples(abnd []map[string]float64, ids []string,
+) ([]map[string]float64, []string, error) {
+	totals := make([]int, len(abnd))
+	for i, m := range abnd {
+		for name, v := range m {
+			if v != math.Floor(v) || v < 0 {
+				return nil, nil, fmt.Errorf("sample %s: rarefaction requires "+
+					"non-negative integer counts, found %v for species %q",
+					ids[i], v, name)
+			}
+			totals[i] += int(v)
+		}
+	}
+	depth := *rareDepth
+	if depth == 0 && len(totals) > 0 {
+		depth = slices.Min(totals)
+	}
+	fmt.Fprintln(os.Stderr, "Rarefying to", depth)
+
+	var keptAbnd []map[string]float64
+	var keptIDs []string
+	for i, m := range abnd {
+		if totals[i] < depth {
+			continue
+		}
+		rnd := rand.New(rand.NewPCG(*seed, uint64(i)))
+		keptAbnd = append(keptAbnd, rarefy(m, depth, rnd))
+		keptIDs = append(keptIDs, ids[i])
+	}
+	if n := len(abnd) - len(keptAbnd); n > 0 {
+		fmt.Fprintf(os.Stderr, "Dropped %d samples with less than %d counts\n",
+			n, depth)
+	}
+	if len(keptAbnd) < 2 {
+		return nil, nil, fmt.Errorf("need at least 2 samples after "+
+			"rarefaction, have %d", len(keptAbnd))
+	}
+	return keptAbnd, keptIDs, nil
+}
+
+// Returns a random subsample of depth counts from the given integer counts,
+// without replacement.
+func rarefy(m map[string]float64, depth int, rnd *rand.Rand,
+) map[string]float64 {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names) // For determinism.
+
+	cum := make([]int, len(names)) // Cumulative counts.
+	total := 0
+	for i, name := range names {
+		total += int(m[name])
+		cum[i] = total
+	}
+
+	// Floyd's algorithm for sampling positions without replacement.
+	chosen := make(map[int]struct{}, depth)
+	for j := total - depth; j < total; j++ {
+		t := rnd.IntN(j + 1)
+		if _, ok := chosen[t]; ok {
+			t = j
+		}
+		chosen[t] = struct{}{}
+	}
+
+	result := map[string]float64{}
+	for pos := range chosen {
+		i := sort.SearchInts(cum, pos+1)
+		result[names[i]]++
+	}
+	return result
+}
+
+// Reads a two-column tab-separated file of species names and their copy
+// numbers or genome sizes.
+func readCopyNumbers(file string) (map[string]float64, error) {
+	f, err := aio.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	result := map[string]float64{}
+	sc := bufio.NewScanner(f)
+	i := 0
+	for sc.Scan() {
+		i++
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		parts := strings.Split(sc.Text(), "\t")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("row #%d: has %d columns, expected 2",
+				i, len(parts))
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("row #%d: %v", i, err)
+		}
+		if v <= 0 {
+			return nil, fmt.Errorf("row #%d: copy number should be positive, "+
+				"found %v", i, v)
+		}
+		result[strings.TrimSpace(parts[0])] = v
+	}
+	return result, sc.Err()
+}
+
+// Divides each abundance by its species' factor. Returns the number of
+// species that have no factor.
+func divideByCopyNumbers(abnd []map[string]float64,
+	factors map[string]float64) int {
+	missing := map[string]struct{}{}
+	for _, m := range abnd {
+		for name, v := range m {
+			f, ok := factors[name]
+			if !ok {
+				missing[name] = struct{}{}
+				continue
+			}
+			m[name] = v / f
+		}
+	}
+	return len(missing)
+}
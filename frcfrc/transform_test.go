@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestRarefy(t *testing.T) {
+	m := map[string]float64{"a": 100, "b": 10, "c": 1, "d": 50}
+	for seed := range 20 {
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+		got := rarefy(m, 30, rnd)
+		total := 0.0
+		for k, v := range got {
+			if v > m[k] {
+				t.Fatalf("rarefy(%v)[%q]=%v, want at most %v", m, k, v, m[k])
+			}
+			total += v
+		}
+		if total != 30 {
+			t.Fatalf("rarefy(%v) sums up to %v, want 30", m, total)
+		}
+	}
+	if got := rarefy(m, 161, rand.New(rand.NewPCG(1, 2))); len(got) != 4 ||
+		got["a"] != 100 || got["b"] != 10 || got["c"] != 1 || got["d"] != 50 {
+		t.Fatalf("rarefy(%v, 161)=%v, want %v", m, got, m)
+	}
+}
+
+func TestHellinger(t *testing.T) {
+	m := map[string]float64{"a": 1, "b": 3}
+	hellinger(m)
+	if math.Abs(m["a"]-0.5) > 1e-12 || math.Abs(m["b"]-math.Sqrt(0.75)) > 1e-12 {
+		t.Fatalf("hellinger()=%v, want {a:0.5, b:%v}", m, math.Sqrt(0.75))
+	}
+}
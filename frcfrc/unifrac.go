@@ -52,20 +52,45 @@ func abundanceToFlatNodes(abnd map[string]float64, tree *newick.Node,
 	return sum
 }
 
-// Sorts and divides abundances by their sum.
-func normalizeFlatNodes(nodes []flatNode) {
-	sort.Slice(nodes, func(i, j int) bool {
-		return nodes[i].id < nodes[j].id
-	})
-	sum := 0.0
-	for i := range nodes {
-		sum += nodes[i].abnd
+// Divides abundances by their sum, or by the given sum if it is not 0.
+func normalizeFlatNodes(nodes []flatNode, sum float64) {
+	if sum == 0 {
+		for i := range nodes {
+			sum += nodes[i].abnd
+		}
 	}
 	for i := range nodes {
 		nodes[i].abnd /= sum
 	}
 }
 
+// Returns each sample's sum of abundances over the flat nodes of the tree,
+// which normalizeFlatNodes divides by. Computing it before pruning keeps the
+// normalization of the full tree, where each leaf's abundance is counted once
+// for every node on its path to the root.
+func flatNodeSums(abnd []map[string]float64, tree *newick.Node) []float64 {
+	depths := map[string]float64{}
+	var walk func(n *newick.Node, depth float64)
+	walk = func(n *newick.Node, depth float64) {
+		if len(n.Children) == 0 {
+			depths[n.Name] += depth
+		}
+		for _, c := range n.Children {
+			walk(c, depth+1)
+		}
+	}
+	walk(tree, 1)
+	sums := make([]float64, len(abnd))
+	for i, m := range abnd {
+		for name, a := range m {
+			if a > 0 {
+				sums[i] += a * depths[name]
+			}
+		}
+	}
+	return sums
+}
+
 // Returns all the unique names in the tree.
 func treeNames(tree *newick.Node) map[string]struct{} {
 	m := map[string]struct{}{}
@@ -93,20 +118,39 @@ func validateSpecies(abnd []map[string]float64, tree *newick.Node) error {
 }
 
 // Returns the unifrac distances between the given abundances, in flat pyramid
-// order.
-func unifrac(abnd []map[string]float64, tree *newick.Node, weighted bool,
-) iter.Seq[float64] {
+// order. Normalizes the samples by norms if it is not nil, or else by their
+// sums over the tree's flat nodes.
+func unifrac(abnd []map[string]float64, tree *newick.Node, norms []float64,
+	weighted bool) iter.Seq[float64] {
+	fmt.Fprintln(os.Stderr, "Converting abundances")
+	sets, treeDists := toFlatNodes(abnd, tree, norms)
+	runtime.GC()
+	fmt.Fprintln(os.Stderr, "Calculating distances")
+	return unifracDists(sets, treeDists, weighted)
+}
+
+// Converts the given abundances to sets of flat nodes, normalized by norms if
+// it is not nil. Returns the sets and the branch length of each node ID.
+func toFlatNodes(abnd []map[string]float64, tree *newick.Node, norms []float64,
+) ([][]flatNode, []float64) {
 	sets := make([][]flatNode, 0, len(abnd))
 	enum := enumerateNodes(tree)
-	fmt.Fprintln(os.Stderr, "Converting abundances")
 	ppln.Serial[map[string]float64, []flatNode](
 		*nt,
 		ppln.SliceInput(abnd),
-		func(a map[string]float64, _, _ int) ([]flatNode, error) {
+		func(a map[string]float64, i, _ int) ([]flatNode, error) {
 			var set []flatNode
 			abundanceToFlatNodes(a, tree, enum, &set)
+			// Distances merge the sets by node ID.
+			sort.Slice(set, func(x, y int) bool {
+				return set[x].id < set[y].id
+			})
 			if !*nnorm {
-				normalizeFlatNodes(set)
+				sum := 0.0
+				if norms != nil {
+					sum = norms[i]
+				}
+				normalizeFlatNodes(set, sum)
 			}
 			return set, nil
 		},
@@ -118,9 +162,7 @@ func unifrac(abnd []map[string]float64, tree *newick.Node, weighted bool,
 	for k, v := range enum {
 		treeDists[v] = k.Distance
 	}
-	runtime.GC()
-	fmt.Fprintln(os.Stderr, "Calculating distances")
-	return unifracDists(sets, treeDists, weighted)
+	return sets, treeDists
 }
 
 // Assigns an arbitrary unique number to each node in the tree.
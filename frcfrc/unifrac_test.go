@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 	"strings"
 	"testing"
@@ -21,7 +22,7 @@ func TestUniFrac_simple(t *testing.T) {
 	}
 	want := []float64{6.0 / 9.0}
 	var got []float64
-	for f := range unifrac(abnd, tree, false) {
+	for f := range unifrac(abnd, tree, nil, false) {
 		got = append(got, f)
 	}
 	if !reflect.DeepEqual(got, want) {
@@ -43,7 +44,7 @@ func TestUniFrac_complex(t *testing.T) {
 	}
 	want := []float64{19.0 / 28.0, 16.0 / 22.0, 1.0}
 	var got []float64
-	for f := range unifrac(abnd, tree, false) {
+	for f := range unifrac(abnd, tree, nil, false) {
 		got = append(got, f)
 	}
 	if !reflect.DeepEqual(got, want) {
@@ -64,7 +65,7 @@ func TestUniFrac_weighted(t *testing.T) {
 	}
 	want := []float64{22.0 / 36.0}
 	var got []float64
-	for f := range unifrac(abnd, tree, true) {
+	for f := range unifrac(abnd, tree, nil, true) {
 		got = append(got, f)
 	}
 	if !reflect.DeepEqual(got, want) {
@@ -73,9 +74,159 @@ func TestUniFrac_weighted(t *testing.T) {
 	}
 }
 
+// Each sample is normalized by its abundance summed over all of the tree's
+// nodes, not by its total abundance.
+func TestUniFrac_weightedNormalization(t *testing.T) {
+	treeText := "(((s1:1,s2:3):2,s3:1):1,s4:5);"
+	tree, err := parseTree(treeText)
+	if err != nil {
+		t.Fatal("failed to parse tree:", err)
+	}
+	abnd := []map[string]float64{
+		{"s1": 4, "s2": 1},
+		{"s3": 3, "s4": 5},
+		{"s1": 1, "s2": 1, "s3": 1, "s4": 1},
+	}
+	want := []float64{0.884393063583815, 0.3642172523961661,
+		0.578512396694215}
+	var got []float64
+	for f := range unifrac(abnd, tree, nil, true) {
+		got = append(got, f)
+	}
+	for i := range want {
+		if len(got) != len(want) || math.Abs(got[i]-want[i]) > 1e-12 {
+			t.Fatalf("unifrac(%v, %q, true)=%v, want %v",
+				abnd, treeText, got, want)
+		}
+	}
+}
+
 func parseTree(s string) (*newick.Node, error) {
 	for tr, err := range newick.Reader(strings.NewReader(s)) {
 		return tr, err
 	}
 	return nil, fmt.Errorf("no tree provided")
 }
+
+func TestExplainDist(t *testing.T) {
+	treeText := "((s1:1,s2:3):2,(s3:2,s4:5):1);"
+	tree, err := parseTree(treeText)
+	if err != nil {
+		t.Fatal("failed to parse tree:", err)
+	}
+	abnd := []map[string]float64{
+		{"s1": 4, "s2": 1},
+		{"s3": 3, "s2": 2},
+	}
+	for _, weighted := range []bool{false, true} {
+		sets, treeDists := toFlatNodes(abnd, tree, nil)
+		want := unifracDistUnweighted(sets[0], sets[1], treeDists)
+		if weighted {
+			want = unifracDistWeighted(sets[0], sets[1], treeDists)
+		}
+		contribs := explainDist(sets[0], sets[1], treeDists, weighted)
+		numer, denom := 0.0, 0.0
+		for i, c := range contribs {
+			if i > 0 && c.numer > contribs[i-1].numer {
+				t.Fatalf("explainDist(%v, %q, %v) is not sorted: %v",
+					abnd, treeText, weighted, contribs)
+			}
+			numer += c.numer
+			denom += c.denom
+		}
+		if got := numer / denom; math.Abs(got-want) > 1e-12 {
+			t.Fatalf("explainDist(%v, %q, %v) sums up to %v, want %v",
+				abnd, treeText, weighted, got, want)
+		}
+	}
+}
+
+// Pruning keeps the distances, with and without normalization.
+func TestUniFrac_pruned(t *testing.T) {
+	defer func(nnorm0, prune0 bool) {
+		*nnorm, *prune = nnorm0, prune0
+	}(*nnorm, *prune)
+	*prune = true
+	treeText := "((s1:1,(s2:3,s5:1):2):2,((s3:2,s6:4):1,s4:5):1);"
+	abnd := []map[string]float64{
+		{"s1": 4, "s2": 1},
+		{"s3": 3, "s2": 2},
+		{"s1": 1, "s3": 1},
+	}
+	for _, unnormalized := range []bool{false, true} {
+		for _, weighted := range []bool{false, true} {
+			*nnorm = unnormalized
+			tree, err := parseTree(treeText)
+			if err != nil {
+				t.Fatal("failed to parse tree:", err)
+			}
+			var want []float64
+			for f := range unifrac(abnd, tree, nil, weighted) {
+				want = append(want, f)
+			}
+			pruned, norms, err := prepareTree(tree, abnd)
+			if err != nil {
+				t.Fatalf("prepareTree(%q, %v) failed: %v",
+					treeText, abnd, err)
+			}
+			var got []float64
+			for f := range unifrac(abnd, pruned, norms, weighted) {
+				got = append(got, f)
+			}
+			for i := range want {
+				if math.Abs(got[i]-want[i]) > 1e-12 {
+					t.Fatalf("unifrac(%v, pruned %q, %v) with -l=%v "+
+						"is %v, want %v", abnd, treeText, weighted,
+						unnormalized, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestFlatNodeSums(t *testing.T) {
+	tree, err := parseTree("((s1:1,s2:3):2,(s3:2,s4:5):1);")
+	if err != nil {
+		t.Fatal("failed to parse tree:", err)
+	}
+	abnd := []map[string]float64{
+		{"s2": 1, "s3": 2},
+		{"s1": 0.5, "s4": -1},
+	}
+	got := flatNodeSums(abnd, tree)
+	want := []float64{9, 1.5}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("flatNodeSums(%v)=%v, want %v", abnd, got, want)
+	}
+}
+
+func TestUniFrac_unnormalized(t *testing.T) {
+	defer func(nnorm0 bool) { *nnorm = nnorm0 }(*nnorm)
+	*nnorm = true
+	treeText := "((s1:1,s2:3):2,(s3:2,s4:5):1);"
+	abnd := []map[string]float64{
+		{"s2": 1, "s3": 1},
+		{"s1": 1, "s3": 1},
+	}
+	tests := []struct {
+		weighted bool
+		want     float64
+	}{
+		{false, 4.0 / 9},
+		{true, 4.0 / 14},
+	}
+	for _, test := range tests {
+		tree, err := parseTree(treeText)
+		if err != nil {
+			t.Fatal("failed to parse tree:", err)
+		}
+		var got []float64
+		for f := range unifrac(abnd, tree, nil, test.weighted) {
+			got = append(got, f)
+		}
+		if len(got) != 1 || math.Abs(got[0]-test.want) > 1e-12 {
+			t.Errorf("unifrac(%v, %q, %v)=%v, want [%v]",
+				abnd, treeText, test.weighted, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Metadata is a table of sample attributes.
+type Metadata struct {
+	Columns []string            // Column names, excluding the sample ID column.
+	IDs     []string            // Sample IDs, by order of appearance.
+	Rows    map[string][]string // Values of each sample, by sample ID.
+}
+
+// ParseMetadata parses a tab-separated sample metadata table. The first row
+// holds column names and the first column holds sample IDs.
+func ParseMetadata(r io.Reader) (*Metadata, error) {
+	m := &Metadata{Rows: map[string][]string{}}
+	i := 0
+	for row, err := range iterRows(r) {
+		if err != nil {
+			return nil, err
+		}
+		i++
+		if strings.TrimSpace(row) == "" {
+			continue
+		}
+		parts := strings.Split(strings.TrimRight(row, "\r"), "\t")
+		for j := range parts {
+			parts[j] = strings.TrimSpace(parts[j])
+		}
+		if m.Columns == nil {
+			if len(parts) < 2 {
+				return nil, fmt.Errorf("row #%d: expected at least 2 columns, "+
+					"found %d", i, len(parts))
+			}
+			m.Columns = parts[1:]
+			continue
+		}
+		if len(parts) != len(m.Columns)+1 {
+			return nil, fmt.Errorf("row #%d: has %d values, expected %d",
+				i, len(parts), len(m.Columns)+1)
+		}
+		id := parts[0]
+		if _, ok := m.Rows[id]; ok {
+			return nil, fmt.Errorf("row #%d: duplicate sample ID: %q", i, id)
+		}
+		m.IDs = append(m.IDs, id)
+		m.Rows[id] = parts[1:]
+	}
+	if m.Columns == nil {
+		return nil, fmt.Errorf("metadata table is empty")
+	}
+	return m, nil
+}
+
+// Column returns the values of the given column, by sample ID.
+func (m *Metadata) Column(name string) (map[string]string, error) {
+	col := -1
+	for i, c := range m.Columns {
+		if c == name {
+			col = i
+			break
+		}
+	}
+	if col == -1 {
+		return nil, fmt.Errorf("metadata has no column %q", name)
+	}
+	result := make(map[string]string, len(m.Rows))
+	for id, row := range m.Rows {
+		result[id] = row[col]
+	}
+	return result, nil
+}
+
+// Groups returns the group number of each of the given samples and the name
+// of each group, by the values of the given column. Groups are numbered by
+// order of appearance.
+func (m *Metadata) Groups(column string, ids []string) ([]int, []string,
+	error) {
+	col, err := m.Column(column)
+	if err != nil {
+		return nil, nil, err
+	}
+	groups := make([]int, len(ids))
+	groupNums := map[string]int{}
+	var names []string
+	for i, id := range ids {
+		val, ok := col[id]
+		if !ok {
+			return nil, nil, fmt.Errorf("sample %q is not in the metadata", id)
+		}
+		g, ok := groupNums[val]
+		if !ok {
+			g = len(names)
+			groupNums[val] = g
+			names = append(names, val)
+		}
+		groups[i] = g
+	}
+	return groups, names, nil
+}
@@ -78,3 +78,141 @@ func TestSplitSparse_bad(t *testing.T) {
 		}
 	}
 }
+
+func TestParseMetadata(t *testing.T) {
+	input := "id\tsite\tsubject\ns1\tgut\tA\ns2\toral cavity\tB\n\ns3\tgut\tB\n"
+	m, err := ParseMetadata(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseMetadata(%q) failed: %v", input, err)
+	}
+	wantIDs := []string{"s1", "s2", "s3"}
+	if !reflect.DeepEqual(m.IDs, wantIDs) {
+		t.Fatalf("ParseMetadata(%q).IDs=%v, want %v", input, m.IDs, wantIDs)
+	}
+	got, err := m.Column("site")
+	if err != nil {
+		t.Fatalf("Column(%q) failed: %v", "site", err)
+	}
+	want := map[string]string{"s1": "gut", "s2": "oral cavity", "s3": "gut"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Column(%q)=%v, want %v", "site", got, want)
+	}
+	if got, err := m.Column("age"); err == nil {
+		t.Fatalf("Column(%q)=%v, want error", "age", got)
+	}
+
+	groups, names, err := m.Groups("subject", []string{"s3", "s1", "s2"})
+	if err != nil {
+		t.Fatalf("Groups(%q) failed: %v", "subject", err)
+	}
+	if want := []int{0, 1, 0}; !reflect.DeepEqual(groups, want) {
+		t.Fatalf("Groups(%q)=%v, want %v", "subject", groups, want)
+	}
+	if want := []string{"B", "A"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("Groups(%q) names=%v, want %v", "subject", names, want)
+	}
+	if _, _, err := m.Groups("site", []string{"s1", "s4"}); err == nil {
+		t.Fatalf("Groups(%q) with an unknown sample succeeded, want error",
+			"site")
+	}
+}
+
+func TestParseMetadata_bad(t *testing.T) {
+	tests := []string{
+		"",
+		"id\n",
+		"id\tsite\ns1\tgut\ns1\toral\n",
+		"id\tsite\ns1\tgut\tA\n",
+	}
+	for _, test := range tests {
+		if m, err := ParseMetadata(strings.NewReader(test)); err == nil {
+			t.Errorf("ParseMetadata(%q)=%v, want error", test, m)
+		}
+	}
+}
+
+func TestParseBracken(t *testing.T) {
+	input := "name\ttaxonomy_id\ttaxonomy_lvl\tkraken_assigned_reads\t" +
+		"added_reads\tnew_est_reads\tfraction_total_reads\n" +
+		"Escherichia coli\t562\tS\t100\t20\t120\t0.6\n" +
+		"Bacteroides fragilis\t817\tS\t70\t10\t80\t0.4\n" +
+		"Nothing\t1\tS\t0\t0\t0\t0\n"
+	got, err := ParseBracken(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseBracken() failed: %v", err)
+	}
+	want := map[string]float64{"Escherichia coli": 120,
+		"Bacteroides fragilis": 80}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseBracken()=%v, want %v", got, want)
+	}
+}
+
+func TestParseKraken2(t *testing.T) {
+	input := " 10.00\t100\t100\tU\t0\tunclassified\n" +
+		" 90.00\t900\t0\tR\t1\troot\n" +
+		" 50.00\t500\t10\tG\t561\t      Escherichia\n" +
+		" 40.00\t400\t390\tS\t562\t        Escherichia coli\n" +
+		"  9.00\t90\t90\tS1\t83333\t          Escherichia coli K-12\n" +
+		" 10.00\t100\t100\tS\t564\t        Escherichia fergusonii\n"
+	got, err := ParseKraken2(strings.NewReader(input), "S")
+	if err != nil {
+		t.Fatalf("ParseKraken2() failed: %v", err)
+	}
+	want := map[string]float64{"Escherichia coli": 400,
+		"Escherichia fergusonii": 100}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseKraken2()=%v, want %v", got, want)
+	}
+}
+
+func TestParseSourmashGather(t *testing.T) {
+	input := "intersect_bp,f_orig_query,f_unique_weighted,name\n" +
+		"1000,0.1,0.25,\"GCF_000005845.2 Escherichia coli, K-12\"\n" +
+		"500,0.05,0.125,GCF_000009925.1 Bacteroides\n"
+	got, err := ParseSourmashGather(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseSourmashGather() failed: %v", err)
+	}
+	want := map[string]float64{"GCF_000005845.2": 0.25,
+		"GCF_000009925.1": 0.125}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseSourmashGather()=%v, want %v", got, want)
+	}
+}
+
+func TestSampleID(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"a/b/sample1.bracken", "sample1"},
+		{"sample1.kreport.gz", "sample1"},
+		{"s.1.csv", "s.1"},
+		{"sample", "sample"},
+		{".hidden", ".hidden"},
+	}
+	for _, test := range tests {
+		if got := SampleID(test.file); got != test.want {
+			t.Errorf("SampleID(%q)=%q, want %q", test.file, got, test.want)
+		}
+	}
+}
+
+func TestParseTwoColumns(t *testing.T) {
+	input := "species\tcount\nE. coli\t3\n\nB. fragilis \t 1.5\nX\t0\n"
+	got, err := ParseTwoColumns(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseTwoColumns() failed: %v", err)
+	}
+	want := map[string]float64{"E. coli": 3, "B. fragilis": 1.5}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseTwoColumns()=%v, want %v", got, want)
+	}
+	for _, input := range []string{"a\t1\nb\tx\n", "a\t1\na\t2\n", "a\t-1\n",
+		"a\t1\t2\n"} {
+		if _, err := ParseTwoColumns(strings.NewReader(input)); err == nil {
+			t.Errorf("ParseTwoColumns(%q) succeeded, want error", input)
+		}
+	}
+}
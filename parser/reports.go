@@ -0,0 +1,201 @@
+package parser
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ParseTwoColumns parses a single sample's tab-separated table of species
+// names and their abundances. A header row is skipped if present.
+func ParseTwoColumns(r io.Reader) (map[string]float64, error) {
+	result := map[string]float64{}
+	i := 0
+	for row, err := range iterRows(r) {
+		if err != nil {
+			return nil, err
+		}
+		i++
+		if strings.TrimSpace(row) == "" {
+			continue
+		}
+		parts := strings.Split(strings.TrimRight(row, "\r"), "\t")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("row #%d: has %d values, expected 2",
+				i, len(parts))
+		}
+		species := strings.TrimSpace(parts[0])
+		f, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			if i == 1 { // Header.
+				continue
+			}
+			return nil, fmt.Errorf("row #%d: %v", i, err)
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) || f < 0 {
+			return nil, fmt.Errorf("row #%d: bad value: %f", i, f)
+		}
+		if species == "" {
+			return nil, fmt.Errorf("row #%d: empty species name", i)
+		}
+		if _, ok := result[species]; ok {
+			return nil, fmt.Errorf("row #%d: duplicate species: %q", i,
+				species)
+		}
+		if f > 0 {
+			result[species] = f
+		}
+	}
+	return result, nil
+}
+
+// ParseBracken parses a Bracken report of a single sample. Returns a map from
+// species name to its estimated number of reads.
+func ParseBracken(r io.Reader) (map[string]float64, error) {
+	var nameCol, readsCol int
+	var header []string
+	result := map[string]float64{}
+	i := 0
+	for row, err := range iterRows(r) {
+		if err != nil {
+			return nil, err
+		}
+		i++
+		if strings.TrimSpace(row) == "" {
+			continue
+		}
+		parts := strings.Split(strings.TrimRight(row, "\r"), "\t")
+		if header == nil {
+			header = parts
+			nameCol = columnIndex(header, "name")
+			readsCol = columnIndex(header, "new_est_reads")
+			if nameCol == -1 || readsCol == -1 {
+				return nil, fmt.Errorf("bracken header should have name and " +
+					"new_est_reads columns")
+			}
+			continue
+		}
+		if len(parts) != len(header) {
+			return nil, fmt.Errorf("row #%d: has %d values, expected %d",
+				i, len(parts), len(header))
+		}
+		reads, err := strconv.ParseFloat(
+			strings.TrimSpace(parts[readsCol]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("row #%d: %v", i, err)
+		}
+		if reads > 0 {
+			result[strings.TrimSpace(parts[nameCol])] += reads
+		}
+	}
+	if header == nil {
+		return nil, fmt.Errorf("bracken report is empty")
+	}
+	return result, nil
+}
+
+// ParseKraken2 parses a Kraken2 report of a single sample. Returns a map from
+// the name of each taxon of the given rank code (such as S for species) to the
+// number of reads assigned to its clade.
+func ParseKraken2(r io.Reader, rank string) (map[string]float64, error) {
+	result := map[string]float64{}
+	i := 0
+	for row, err := range iterRows(r) {
+		if err != nil {
+			return nil, err
+		}
+		i++
+		if strings.TrimSpace(row) == "" {
+			continue
+		}
+		// Reports with minimizer data have 2 more columns before the rank.
+		parts := strings.Split(strings.TrimRight(row, "\r"), "\t")
+		if len(parts) != 6 && len(parts) != 8 {
+			return nil, fmt.Errorf("row #%d: has %d values, expected 6 or 8",
+				i, len(parts))
+		}
+		if strings.TrimSpace(parts[len(parts)-3]) != rank {
+			continue
+		}
+		reads, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("row #%d: %v", i, err)
+		}
+		if reads > 0 {
+			result[strings.TrimSpace(parts[len(parts)-1])] += reads
+		}
+	}
+	return result, nil
+}
+
+// ParseSourmashGather parses the CSV output of sourmash gather for a single
+// sample. Returns a map from each match's identifier, which is the first word
+// of its name, to its weighted fraction of the sample.
+func ParseSourmashGather(r io.Reader) (map[string]float64, error) {
+	rd := csv.NewReader(r)
+	header, err := rd.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("sourmash gather output is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	nameCol := columnIndex(header, "name")
+	fracCol := columnIndex(header, "f_unique_weighted")
+	if nameCol == -1 || fracCol == -1 {
+		return nil, fmt.Errorf("sourmash gather header should have name and " +
+			"f_unique_weighted columns")
+	}
+	result := map[string]float64{}
+	for {
+		row, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		frac, err := strconv.ParseFloat(row[fracCol], 64)
+		if err != nil {
+			line, _ := rd.FieldPos(fracCol)
+			return nil, fmt.Errorf("row #%d: %v", line, err)
+		}
+		name := strings.Fields(row[nameCol])
+		if len(name) == 0 {
+			line, _ := rd.FieldPos(nameCol)
+			return nil, fmt.Errorf("row #%d: empty name", line)
+		}
+		if frac > 0 {
+			result[name[0]] += frac
+		}
+	}
+	return result, nil
+}
+
+// SampleID returns the sample ID of an input file, which is its base name
+// without extension. Compression extensions are removed too.
+func SampleID(file string) string {
+	name := filepath.Base(file)
+	switch filepath.Ext(name) {
+	case ".gz", ".bz2", ".zst":
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	if id := strings.TrimSuffix(name, filepath.Ext(name)); id != "" {
+		return id
+	}
+	return name
+}
+
+// Returns the index of the given column name, or -1 if not found.
+func columnIndex(header []string, name string) int {
+	for i, h := range header {
+		if strings.TrimSpace(h) == name {
+			return i
+		}
+	}
+	return -1
+}
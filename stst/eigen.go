@@ -0,0 +1,384 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+
+	"github.com/fluhus/gostuff/ppln"
+)
+
+const (
+	// Number of extra dimensions used by the randomized solver.
+	randomOversampling = 10
+
+	// Number of power iterations used by the randomized solver.
+	randomPowerIters = 5
+)
+
+// Returns the eigenvalues and eigenvectors of the symmetric matrix a, sorted by
+// descending eigenvalue. Eigenvector i is vecs[i]. Overwrites a.
+//
+// Uses Householder tridiagonalization followed by the implicit QL algorithm,
+// adapted from JAMA (public domain). Works in O(n^3) time.
+func symEigen(a [][]float64) (vals []float64, vecs [][]float64) {
+	n := len(a)
+	if n == 0 {
+		return nil, nil
+	}
+	d := make([]float64, n)
+	e := make([]float64, n)
+	tridiagonalize(a, d, e)
+	tridiagonalQL(a, d, e)
+
+	idx := make([]int, n)
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		return d[idx[i]] > d[idx[j]]
+	})
+	vals = make([]float64, n)
+	vecs = make([][]float64, n)
+	for i, j := range idx {
+		vals[i] = d[j]
+		vecs[i] = a[j]
+	}
+	return vals, vecs
+}
+
+// Reduces the symmetric matrix w to tridiagonal form. On return, d holds the
+// diagonal, e holds the subdiagonal in e[1:], and w holds the transpose of the
+// accumulated orthogonal transformation.
+//
+// Indexes are transposed compared to JAMA, so that inner loops run on rows.
+func tridiagonalize(w [][]float64, d, e []float64) {
+	n := len(w)
+	for j := range n {
+		d[j] = w[j][n-1]
+	}
+
+	for i := n - 1; i > 0; i-- {
+		scale := 0.0
+		h := 0.0
+		for k := range i {
+			scale += math.Abs(d[k])
+		}
+		if scale == 0 {
+			e[i] = d[i-1]
+			for j := range i {
+				d[j] = w[j][i-1]
+				w[j][i] = 0
+				w[i][j] = 0
+			}
+		} else {
+			for k := range i {
+				d[k] /= scale
+				h += d[k] * d[k]
+			}
+			f := d[i-1]
+			g := math.Sqrt(h)
+			if f > 0 {
+				g = -g
+			}
+			e[i] = scale * g
+			h -= f * g
+			d[i-1] = f - g
+			for j := range i {
+				e[j] = 0
+			}
+			for j := range i {
+				f = d[j]
+				w[i][j] = f
+				wj := w[j]
+				g = e[j] + wj[j]*f
+				for k := j + 1; k < i; k++ {
+					g += wj[k] * d[k]
+					e[k] += wj[k] * f
+				}
+				e[j] = g
+			}
+			f = 0
+			for j := range i {
+				e[j] /= h
+				f += e[j] * d[j]
+			}
+			hh := f / (h + h)
+			for j := range i {
+				e[j] -= hh * d[j]
+			}
+			for j := range i {
+				f = d[j]
+				g = e[j]
+				wj := w[j]
+				for k := j; k < i; k++ {
+					wj[k] -= f*e[k] + g*d[k]
+				}
+				d[j] = wj[i-1]
+				wj[i] = 0
+			}
+		}
+		d[i] = h
+	}
+
+	// Accumulate transformations.
+	for i := range n - 1 {
+		w[i][n-1] = w[i][i]
+		w[i][i] = 1
+		h := d[i+1]
+		wi1 := w[i+1]
+		if h != 0 {
+			for k := 0; k <= i; k++ {
+				d[k] = wi1[k] / h
+			}
+			for j := 0; j <= i; j++ {
+				wj := w[j]
+				g := 0.0
+				for k := 0; k <= i; k++ {
+					g += wi1[k] * wj[k]
+				}
+				for k := 0; k <= i; k++ {
+					wj[k] -= g * d[k]
+				}
+			}
+		}
+		for k := 0; k <= i; k++ {
+			wi1[k] = 0
+		}
+	}
+	for j := range n {
+		d[j] = w[j][n-1]
+		w[j][n-1] = 0
+	}
+	w[n-1][n-1] = 1
+	e[0] = 0
+}
+
+// Diagonalizes the tridiagonal matrix given by d and e, as returned by
+// tridiagonalize. On return, d holds the eigenvalues and the rows of z hold
+// the eigenvectors. z should hold the transformation from tridiagonalize.
+func tridiagonalQL(z [][]float64, d, e []float64) {
+	n := len(z)
+	for i := 1; i < n; i++ {
+		e[i-1] = e[i]
+	}
+	e[n-1] = 0
+
+	f := 0.0
+	tst1 := 0.0
+	eps := math.Pow(2, -52)
+	for l := range n {
+		tst1 = max(tst1, math.Abs(d[l])+math.Abs(e[l]))
+		m := l
+		for m < n-1 && math.Abs(e[m]) > eps*tst1 {
+			m++
+		}
+		if m > l {
+			for {
+				g := d[l]
+				p := (d[l+1] - g) / (2 * e[l])
+				r := math.Hypot(p, 1)
+				if p < 0 {
+					r = -r
+				}
+				d[l] = e[l] / (p + r)
+				d[l+1] = e[l] * (p + r)
+				dl1 := d[l+1]
+				h := g - d[l]
+				for i := l + 2; i < n; i++ {
+					d[i] -= h
+				}
+				f += h
+
+				p = d[m]
+				c, c2, c3 := 1.0, 1.0, 1.0
+				el1 := e[l+1]
+				s, s2 := 0.0, 0.0
+				for i := m - 1; i >= l; i-- {
+					c3 = c2
+					c2 = c
+					s2 = s
+					g = c * e[i]
+					h = c * p
+					r = math.Hypot(p, e[i])
+					e[i+1] = s * r
+					s = e[i] / r
+					c = p / r
+					p = c*d[i] - s*g
+					d[i+1] = h + s*(c*g+s*d[i])
+					zi, zi1 := z[i], z[i+1]
+					for k := range n {
+						h = zi1[k]
+						zi1[k] = s*zi[k] + c*h
+						zi[k] = c*zi[k] - s*h
+					}
+				}
+				p = -s * s2 * c3 * el1 * e[l] / dl1
+				e[l] = s * p
+				d[l] = c * p
+				if math.Abs(e[l]) <= eps*tst1 {
+					break
+				}
+			}
+		}
+		d[l] += f
+		e[l] = 0
+	}
+}
+
+// A symmetric linear operator on n-dimensional vectors.
+type symOperator interface {
+	// Returns the dimension of the operator.
+	dim() int
+	// Returns the element at row i and column j.
+	at(i, j int) float64
+}
+
+// Returns approximations of the k largest eigenvalues and their eigenvectors
+// of the given operator, sorted by descending eigenvalue.
+//
+// Uses randomized subspace iteration (Halko et al. 2011). Works in O(n^2*k)
+// time and O(n*k) memory per round, not counting the operator. More rounds are
+// needed only when large negative eigenvalues are found.
+func randomEigen(a symOperator, k int, seed uint64, nt int,
+) (vals []float64, vecs [][]float64) {
+	// Subspace iteration finds the eigenvalues of largest magnitude, so
+	// negative ones may push out positive ones. Those are projected out and
+	// the iteration is repeated, until the top k are positive or larger than
+	// any remaining negative one.
+	var negative [][]float64
+	for {
+		vals, vecs = subspaceEigen(a, k, negative, seed, nt)
+		k = min(k, len(vals))
+		// The smallest positive eigenvalue among the top k.
+		low := 0.0
+		for _, v := range vals[:k] {
+			if v > 0 {
+				low = v
+			}
+		}
+		i := len(vals) - 1
+		for i >= 0 && vals[i] < 0 && -vals[i] >= low {
+			negative = append(negative, vecs[i])
+			i--
+		}
+		if i == len(vals)-1 {
+			return vals[:k], vecs[:k]
+		}
+	}
+}
+
+// Returns approximations of the eigenvalues of largest magnitude and their
+// eigenvectors of the given operator, at least k of them, sorted by
+// descending eigenvalue. The operator is restricted to the subspace orthogonal
+// to the given orthonormal vectors.
+func subspaceEigen(a symOperator, k int, exclude [][]float64, seed uint64,
+	nt int) (vals []float64, vecs [][]float64) {
+	n := a.dim()
+	l := min(k+randomOversampling, n-len(exclude))
+	rnd := rand.New(rand.NewPCG(seed, seed))
+	q := make([][]float64, l)
+	for i := range q {
+		q[i] = make([]float64, n)
+		for j := range q[i] {
+			q[i][j] = rnd.NormFloat64()
+		}
+	}
+	for range randomPowerIters {
+		projectOut(q, exclude)
+		orthonormalize(q)
+		q = mulOperator(a, q, nt)
+	}
+	projectOut(q, exclude)
+	orthonormalize(q)
+
+	// Solve the small eigenproblem of Q'AQ.
+	aq := mulOperator(a, q, nt)
+	t := make([][]float64, l)
+	for i := range t {
+		t[i] = make([]float64, l)
+		for j := range t[i] {
+			t[i][j] = dot(q[i], aq[j])
+		}
+	}
+	vals, tvecs := symEigen(t)
+	vecs = make([][]float64, l)
+	for i := range vecs {
+		vecs[i] = make([]float64, n)
+		for j, c := range tvecs[i] {
+			for x := range vecs[i] {
+				vecs[i][x] += c * q[j][x]
+			}
+		}
+	}
+	return vals, vecs
+}
+
+// Removes the components of the given orthonormal vectors from vecs.
+func projectOut(vecs, exclude [][]float64) {
+	for _, v := range vecs {
+		for _, e := range exclude {
+			p := dot(v, e)
+			for x := range v {
+				v[x] -= p * e[x]
+			}
+		}
+	}
+}
+
+// Returns the products of a with each of the given vectors.
+func mulOperator(a symOperator, vecs [][]float64, nt int) [][]float64 {
+	n := a.dim()
+	result := make([][]float64, len(vecs))
+	for i := range result {
+		result[i] = make([]float64, n)
+	}
+	ppln.NonSerial(nt,
+		ppln.RangeInput(0, n),
+		func(i, _ int) (int, error) {
+			for j := range n {
+				aij := a.at(i, j)
+				for v := range vecs {
+					result[v][i] += aij * vecs[v][j]
+				}
+			}
+			return 0, nil
+		},
+		func(int) error { return nil },
+	)
+	return result
+}
+
+// Makes the given vectors orthonormal, using modified Gram-Schmidt.
+// Vectors that are linearly dependent on the previous ones are zeroed.
+func orthonormalize(vecs [][]float64) {
+	for i := range vecs {
+		norm0 := math.Sqrt(dot(vecs[i], vecs[i]))
+		// Going twice for numerical stability.
+		for range 2 {
+			for j := range i {
+				p := dot(vecs[i], vecs[j])
+				for x := range vecs[i] {
+					vecs[i][x] -= p * vecs[j][x]
+				}
+			}
+		}
+		norm := math.Sqrt(dot(vecs[i], vecs[i]))
+		if norm <= norm0*1e-10 {
+			clear(vecs[i])
+			continue
+		}
+		for x := range vecs[i] {
+			vecs[i][x] /= norm
+		}
+	}
+}
+
+// Returns the dot product of a and b.
+func dot(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
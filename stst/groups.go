@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fluhus/frackyfrac/parser"
+	"github.com/fluhus/gostuff/aio"
+)
+
+// Returns the IDs of the n samples in the distance matrix. Reads them from
+// the given file, one per line, or uses 1-based serial numbers if file is
+// empty.
+func readSampleIDs(file string, n int) ([]string, error) {
+	if file == "" {
+		ids := make([]string, n)
+		for i := range ids {
+			ids[i] = strconv.Itoa(i + 1)
+		}
+		return ids, nil
+	}
+	f, err := aio.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ids []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if id := strings.TrimSpace(sc.Text()); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) != n {
+		return nil, fmt.Errorf("found %d sample IDs for %d samples",
+			len(ids), n)
+	}
+	return ids, nil
+}
+
+// Returns the group number of each sample and the name of each group,
+// according to the given metadata column.
+func readGroups(metaFile, column string, ids []string) ([]int, []string,
+	error) {
+	f, err := aio.Open(metaFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	meta, err := parser.ParseMetadata(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	groups, names, err := meta.Groups(column, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(names) < 2 {
+		return nil, nil, fmt.Errorf("need at least 2 groups, found %d",
+			len(names))
+	}
+	if len(names) == len(ids) {
+		return nil, nil, fmt.Errorf("each sample is in its own group")
+	}
+	return groups, names, nil
+}
+
+// Returns the number of elements in each group.
+func groupSizes(groups []int, ngroups int) []int {
+	sizes := make([]int, ngroups)
+	for _, g := range groups {
+		sizes[g]++
+	}
+	return sizes
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"iter"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/fluhus/frackyfrac/common"
+)
+
+// Runs the mantel command.
+func mantelMain(args []string) error {
+	fs := newFlagSet("mantel", mantelUsage)
+	fa := fs.String("a", "", "Path to first distances file, required")
+	fb := fs.String("b", "", "Path to second distances file, required")
+	fout := fs.String("o", "", "Path to output file (default stdout)")
+	method := fs.String("c", "pearson", "Correlation method: pearson or "+
+		"spearman")
+	alt := fs.String("alt", "two-sided", "Alternative hypothesis: "+
+		"two-sided, greater or less")
+	nperm := fs.Int("perm", 999, "Number of permutations")
+	seed := fs.Uint64("seed", 0, "Random seed")
+	nt := fs.Int("p", 1, "Number of threads")
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(0)
+	}
+	fs.Parse(args)
+	if *fa == "" || *fb == "" {
+		return fmt.Errorf("please provide 2 distances files with -a and -b")
+	}
+	if *method != "pearson" && *method != "spearman" {
+		return fmt.Errorf("unknown correlation method: %q", *method)
+	}
+	var sign float64
+	switch *alt {
+	case "two-sided":
+		sign = 0
+	case "greater":
+		sign = 1
+	case "less":
+		sign = -1
+	default:
+		return fmt.Errorf("unknown alternative hypothesis: %q", *alt)
+	}
+	if *nperm < 0 {
+		return fmt.Errorf("bad number of permutations: %d", *nperm)
+	}
+	if *nt < 1 {
+		return fmt.Errorf("bad number of threads: %d", *nt)
+	}
+
+	fmt.Fprintln(os.Stderr, "Reading distances")
+	a, b, err := readDistancePair(*fa, *fb)
+	if err != nil {
+		return err
+	}
+	n, err := common.NumElements(len(a))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Found", n, "samples")
+	if n < 3 {
+		return fmt.Errorf("need at least 3 samples, got %d", n)
+	}
+	if *method == "spearman" {
+		a, b = ranks(a), ranks(b)
+	}
+	if !standardize(a) || !standardize(b) {
+		return fmt.Errorf("cannot correlate a matrix with constant distances")
+	}
+
+	fmt.Fprintln(os.Stderr, "Running permutations")
+	perm := make([]int, n)
+	for i := range perm {
+		perm[i] = i
+	}
+	stat := func(perm []int) float64 {
+		r := permutedCorr(a, b, perm)
+		if sign == 0 {
+			return math.Abs(r)
+		}
+		return sign * r
+	}
+	_, p := permutationTest(perm, stat, *nperm, *seed, *nt)
+	r := permutedCorr(a, b, perm)
+
+	w, err := openOutput(*fout)
+	if err != nil {
+		return err
+	}
+	statName := "r"
+	if *method == "spearman" {
+		statName = "rho"
+	}
+	res := &testResult{"Mantel (" + *method + ", " + *alt + ")", statName,
+		n, 0, r, p, *nperm}
+	if err := res.write(w); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// Reads two flat pyramids of distances, streaming them side by side. Returns
+// an error if they are not of the same length.
+func readDistancePair(fa, fb string) ([]float64, []float64, error) {
+	ra, err := openInput(fa)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ra.Close()
+	rb, err := openInput(fb)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rb.Close()
+
+	nextB, stop := iter.Pull2(common.IterDistances(rb))
+	defer stop()
+	var a, b []float64
+	for da, err := range common.IterDistances(ra) {
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", fa, err)
+		}
+		db, err, ok := nextB()
+		if !ok {
+			return nil, nil, fmt.Errorf("%s has more values than %s", fa, fb)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", fb, err)
+		}
+		a = append(a, da)
+		b = append(b, db)
+	}
+	if _, err, ok := nextB(); ok {
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", fb, err)
+		}
+		return nil, nil, fmt.Errorf("%s has more values than %s", fb, fa)
+	}
+	return a, b, nil
+}
+
+// Returns the Pearson correlation between a and b, where the samples of b are
+// reordered by the given permutation. a and b should be standardized.
+func permutedCorr(a, b []float64, perm []int) float64 {
+	sum := 0.0
+	k := 0
+	for i := range perm {
+		pi := perm[i]
+		for j := range i {
+			sum += a[k] * b[common.PairIndex(pi, perm[j])]
+			k++
+		}
+	}
+	return sum
+}
+
+// Subtracts the mean from x and divides it by its norm, so that the dot
+// product of two standardized vectors is their Pearson correlation.
+// Returns false if x is constant.
+func standardize(x []float64) bool {
+	mean := 0.0
+	for _, v := range x {
+		mean += v
+	}
+	mean /= float64(len(x))
+	norm := 0.0
+	for i := range x {
+		x[i] -= mean
+		norm += x[i] * x[i]
+	}
+	if norm == 0 {
+		return false
+	}
+	norm = math.Sqrt(norm)
+	for i := range x {
+		x[i] /= norm
+	}
+	return true
+}
+
+// Returns the ranks of the values in x, starting at 1. Ties get the average
+// of their ranks.
+func ranks(x []float64) []float64 {
+	idx := make([]int, len(x))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		return x[idx[i]] < x[idx[j]]
+	})
+	result := make([]float64, len(x))
+	for i := 0; i < len(idx); {
+		j := i + 1
+		for j < len(idx) && x[idx[j]] == x[idx[i]] {
+			j++
+		}
+		rank := float64(i+j+1) / 2 // Average of i+1..j.
+		for _, k := range idx[i:j] {
+			result[k] = rank
+		}
+		i = j
+	}
+	return result
+}
+
+const mantelUsage = `Tests the correlation between two distance matrices (Mantel test), in
+frcfrc's output format. Both matrices should have the same samples in the same
+order.
+
+Usage:
+stst mantel [PARAMS]
+
+Params:`
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestRanks(t *testing.T) {
+	x := []float64{5, 1, 3, 3, 7, 1, 3}
+	want := []float64{6, 1.5, 4, 4, 7, 1.5, 4}
+	if got := ranks(x); !slices.Equal(got, want) {
+		t.Fatalf("ranks(%v)=%v, want %v", x, got, want)
+	}
+}
+
+func TestPermutedCorr(t *testing.T) {
+	a := []float64{1, 2, 3, 4, 5, 6}
+	b := []float64{2, 4, 6, 8, 10, 12}
+	standardize(a)
+	standardize(b)
+	perm := []int{0, 1, 2, 3}
+	if got := permutedCorr(a, b, perm); !near(got, 1) {
+		t.Fatalf("permutedCorr(%v,%v,%v)=%v, want 1", a, b, perm, got)
+	}
+
+	// Swapping samples 0 and 1 reorders b to 2,6,4,10,8,12.
+	perm = []int{1, 0, 2, 3}
+	a = []float64{1, 2, 3, 4, 5, 6}
+	c := []float64{2, 6, 4, 10, 8, 12}
+	standardize(a)
+	standardize(c)
+	want := 0.0
+	for i := range a {
+		want += a[i] * c[i]
+	}
+	if got := permutedCorr(a, b, perm); math.Abs(got-want) > 1e-9 {
+		t.Fatalf("permutedCorr(%v,%v,%v)=%v, want %v", a, b, perm, got, want)
+	}
+}
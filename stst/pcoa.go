@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/fluhus/frackyfrac/common"
+)
+
+// Above this number of samples, the auto solver uses the randomized solver.
+const maxFullSolverSamples = 2000
+
+// Runs the pcoa command.
+func pcoaMain(args []string) error {
+	fs := newFlagSet("pcoa", pcoaUsage)
+	fin := fs.String("i", "", "Path to distances file (default stdin)")
+	fout := fs.String("o", "", "Path to coordinates output file "+
+		"(default stdout)")
+	fexp := fs.String("e", "", "Path to variance explained output file "+
+		"(default stderr)")
+	naxes := fs.Int("d", 10, "Number of axes to output")
+	solver := fs.String("s", "auto", "Eigen solver: full, random or auto "+
+		"(full up to "+fmt.Sprint(maxFullSolverSamples)+" samples)")
+	seed := fs.Uint64("seed", 0, "Random seed for the randomized solver")
+	nt := fs.Int("p", 1, "Number of threads")
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(0)
+	}
+	fs.Parse(args)
+	if *naxes < 1 {
+		return fmt.Errorf("bad number of axes: %d", *naxes)
+	}
+	if *nt < 1 {
+		return fmt.Errorf("bad number of threads: %d", *nt)
+	}
+
+	fmt.Fprintln(os.Stderr, "Reading distances")
+	d, n, err := readDistances(*fin)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Found", n, "samples")
+	if n < 2 {
+		return fmt.Errorf("need at least 2 samples, got %d", n)
+	}
+
+	solve, err := newSolver(*solver, n, *naxes, *seed, *nt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "Calculating coordinates")
+	res := pcoa(d, n, *naxes, solve)
+
+	w, err := openOutput(*fout)
+	if err != nil {
+		return err
+	}
+	if err := writeRows(w, res.coords); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if *fexp == "" {
+		fmt.Fprintln(os.Stderr, "Variance explained:")
+		for i, e := range res.explained {
+			fmt.Fprintf(os.Stderr, "  PC%d: %.4f\n", i+1, e)
+		}
+		return nil
+	}
+	w, err = openOutput(*fexp)
+	if err != nil {
+		return err
+	}
+	for _, e := range res.explained {
+		if _, err := fmt.Fprintln(w, e); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// Writes the given rows as tab-separated values.
+func writeRows(w io.Writer, rows [][]float64) error {
+	bw := bufio.NewWriter(w)
+	for _, row := range rows {
+		for i, x := range row {
+			if i > 0 {
+				bw.WriteByte('\t')
+			}
+			fmt.Fprint(bw, x)
+		}
+		if _, err := bw.WriteString("\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// The result of a principal coordinates analysis.
+type pcoaResult struct {
+	coords    [][]float64 // Coordinates, one row per sample.
+	eigvals   []float64   // Eigenvalue of each axis.
+	explained []float64   // Proportion of variance explained by each axis.
+}
+
+// A double-centered matrix of squared distances, multiplied by -1/2.
+// Computed on the fly from the flat pyramid of distances.
+type centered struct {
+	d2    []float64 // Squared distances in flat pyramid order.
+	means []float64 // Row means of the squared distances.
+	mean  float64   // Mean of all squared distances.
+}
+
+// Returns the double-centered matrix for the given flat pyramid of distances
+// between n samples.
+func newCentered(d []float64, n int) *centered {
+	c := &centered{
+		d2:    make([]float64, len(d)),
+		means: make([]float64, n),
+	}
+	i := 0
+	for a := range n {
+		for b := range a {
+			x := d[i] * d[i]
+			c.d2[i] = x
+			c.means[a] += x
+			c.means[b] += x
+			c.mean += 2 * x
+			i++
+		}
+	}
+	for a := range c.means {
+		c.means[a] /= float64(n)
+	}
+	c.mean /= float64(n * n)
+	return c
+}
+
+func (c *centered) dim() int {
+	return len(c.means)
+}
+
+func (c *centered) at(i, j int) float64 {
+	d2 := 0.0
+	if i != j {
+		d2 = c.d2[common.PairIndex(i, j)]
+	}
+	return -0.5 * (d2 - c.means[i] - c.means[j] + c.mean)
+}
+
+// Returns the trace of the centered matrix, which is the sum of its
+// eigenvalues.
+func (c *centered) trace() float64 {
+	sum := 0.0
+	for i := range c.means {
+		sum += c.at(i, i)
+	}
+	return sum
+}
+
+// Returns the eigenvalues and eigenvectors of a centered matrix, sorted by
+// descending eigenvalue.
+type eigenSolver func(c *centered) ([]float64, [][]float64)
+
+// Returns the eigen solver with the given name, for the given number of
+// samples and axes.
+func newSolver(name string, n, naxes int, seed uint64, nt int,
+) (eigenSolver, error) {
+	switch name {
+	case "full":
+		return fullSolver, nil
+	case "random":
+		return randomSolver(naxes, seed, nt), nil
+	case "auto":
+		if n <= maxFullSolverSamples {
+			return fullSolver, nil
+		}
+		return randomSolver(naxes, seed, nt), nil
+	default:
+		return nil, fmt.Errorf("unknown solver: %q", name)
+	}
+}
+
+// Returns all the eigenvalues and eigenvectors of the centered matrix.
+func fullSolver(c *centered) ([]float64, [][]float64) {
+	n := c.dim()
+	m := make([][]float64, n)
+	for i := range m {
+		m[i] = make([]float64, n)
+		for j := range i + 1 {
+			m[i][j] = c.at(i, j)
+			m[j][i] = m[i][j]
+		}
+	}
+	return symEigen(m)
+}
+
+// Returns a solver that approximates the top naxes eigenvalues and
+// eigenvectors of the centered matrix, using the randomized solver.
+func randomSolver(naxes int, seed uint64, nt int) eigenSolver {
+	return func(c *centered) ([]float64, [][]float64) {
+		return randomEigen(c, naxes, seed, nt)
+	}
+}
+
+// Performs PCoA using the given eigen solver on the centered matrix.
+func pcoa(d []float64, n, naxes int, solve eigenSolver) *pcoaResult {
+	c := newCentered(d, n)
+	vals, vecs := solve(c)
+	return newPCoAResult(vals, vecs, c.trace(), naxes)
+}
+
+// Creates a PCoA result from the eigen decomposition of the centered matrix.
+// Keeps up to naxes axes with positive eigenvalues.
+func newPCoAResult(vals []float64, vecs [][]float64, trace float64,
+	naxes int) *pcoaResult {
+	minVal := minEigenvalue(trace)
+	k := 0
+	for k < len(vals) && k < naxes && vals[k] > minVal {
+		k++
+	}
+	n := len(vecs[0])
+	res := &pcoaResult{
+		coords:    make([][]float64, n),
+		eigvals:   vals[:k],
+		explained: make([]float64, k),
+	}
+	for i := range res.coords {
+		res.coords[i] = make([]float64, k)
+	}
+	for a := range k {
+		res.explained[a] = vals[a] / trace
+		sq := math.Sqrt(vals[a])
+		for i := range n {
+			res.coords[i][a] = vecs[a][i] * sq
+		}
+	}
+	return res
+}
+
+// Returns the absolute value under which eigenvalues are considered zero, due
+// to rounding errors.
+func minEigenvalue(trace float64) float64 {
+	return math.Abs(trace) * 1e-10
+}
+
+const pcoaUsage = `Performs principal coordinates analysis (classical MDS) on a distance
+matrix in frcfrc's output format.
+Outputs one row per sample, with tab-separated coordinates.
+
+Usage:
+stst pcoa [PARAMS]
+
+Params:`
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/fluhus/frackyfrac/common"
+)
+
+func TestSymEigen(t *testing.T) {
+	a := [][]float64{
+		{2, 1, 0},
+		{1, 2, 0},
+		{0, 0, 5},
+	}
+	wantVals := []float64{5, 3, 1}
+	wantVecs := [][]float64{
+		{0, 0, 1},
+		{math.Sqrt2 / 2, math.Sqrt2 / 2, 0},
+		{math.Sqrt2 / 2, -math.Sqrt2 / 2, 0},
+	}
+	vals, vecs := symEigen(a)
+	for i := range wantVals {
+		if !near(vals[i], wantVals[i]) {
+			t.Fatalf("symEigen(...) vals=%v, want %v", vals, wantVals)
+		}
+		// Eigenvectors are unique up to sign.
+		if !nearVec(vecs[i], wantVecs[i]) &&
+			!nearVec(neg(vecs[i]), wantVecs[i]) {
+			t.Fatalf("symEigen(...) vecs=%v, want %v", vecs, wantVecs)
+		}
+	}
+}
+
+// A dense symmetric matrix.
+type denseOperator [][]float64
+
+func (m denseOperator) dim() int            { return len(m) }
+func (m denseOperator) at(i, j int) float64 { return m[i][j] }
+
+func TestRandomEigen_negative(t *testing.T) {
+	// Negative eigenvalues larger in magnitude than the positive ones.
+	eigvals := []float64{10, 8}
+	for i := range 20 {
+		eigvals = append(eigvals, -float64(i+20))
+	}
+	n := 40
+	rnd := rand.New(rand.NewPCG(1, 1))
+	basis := make([][]float64, len(eigvals))
+	for i := range basis {
+		basis[i] = make([]float64, n)
+		for j := range basis[i] {
+			basis[i][j] = rnd.NormFloat64()
+		}
+	}
+	orthonormalize(basis)
+	m := make(denseOperator, n)
+	for i := range m {
+		m[i] = make([]float64, n)
+		for j := range m[i] {
+			for e, v := range eigvals {
+				m[i][j] += v * basis[e][i] * basis[e][j]
+			}
+		}
+	}
+
+	// The randomized solver is approximate.
+	vals, vecs := randomEigen(m, 2, 0, 1)
+	for i, want := range eigvals[:2] {
+		if math.Abs(vals[i]-want) > 1e-3 {
+			t.Fatalf("randomEigen(...) vals=%v, want %v", vals, eigvals[:2])
+		}
+		if p := math.Abs(dot(vecs[i], basis[i])); math.Abs(p-1) > 1e-3 {
+			t.Fatalf("randomEigen(...) vec #%d has projection %v on its "+
+				"eigenvector, want 1", i, p)
+		}
+	}
+}
+
+func TestPCoA(t *testing.T) {
+	// Points on a 3x4 rectangle.
+	points := [][2]float64{{0, 0}, {4, 0}, {0, 3}, {4, 3}}
+	var d []float64
+	for p := range common.IterPairs(points) {
+		d = append(d, math.Hypot(p[0][0]-p[1][0], p[0][1]-p[1][1]))
+	}
+	wantExp := []float64{16.0 / 25.0, 9.0 / 25.0}
+	for _, res := range []*pcoaResult{
+		pcoa(d, len(points), 10, fullSolver),
+		pcoa(d, len(points), 10, randomSolver(10, 1, 1)),
+	} {
+		if !nearVec(res.explained, wantExp) {
+			t.Fatalf("pcoa(%v).explained=%v, want %v",
+				d, res.explained, wantExp)
+		}
+		// Distances between coordinates should match the input.
+		i := 0
+		for p := range common.IterPairs(res.coords) {
+			got := math.Hypot(p[0][0]-p[1][0], p[0][1]-p[1][1])
+			if !near(got, d[i]) {
+				t.Fatalf("pcoa(%v) pair #%d: distance=%v, want %v",
+					d, i+1, got, d[i])
+			}
+			i++
+		}
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func nearVec(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !near(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func neg(a []float64) []float64 {
+	result := make([]float64, len(a))
+	for i := range a {
+		result[i] = -a[i]
+	}
+	return result
+}
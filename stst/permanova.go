@@ -0,0 +1,211 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+
+	"github.com/fluhus/gostuff/ppln"
+)
+
+// Flags that are common to the group comparison tests.
+type groupTestFlags struct {
+	fin    *string
+	fout   *string
+	fmeta  *string
+	fnames *string
+	column *string
+	nperm  *int
+	seed   *uint64
+	nt     *int
+}
+
+// Registers the group comparison flags on the given flag set.
+func addGroupTestFlags(fs *flag.FlagSet) *groupTestFlags {
+	return &groupTestFlags{
+		fin:  fs.String("i", "", "Path to distances file (default stdin)"),
+		fout: fs.String("o", "", "Path to output file (default stdout)"),
+		fmeta: fs.String("m", "", "Path to tab-separated metadata file, "+
+			"with sample IDs in the first column, required"),
+		fnames: fs.String("n", "", "Path to file with the sample IDs of the "+
+			"distance matrix, one per line (default 1,2,3...)"),
+		column: fs.String("g", "", "Name of the metadata column to group "+
+			"samples by, required"),
+		nperm: fs.Int("perm", 999, "Number of permutations"),
+		seed:  fs.Uint64("seed", 0, "Random seed"),
+		nt:    fs.Int("p", 1, "Number of threads"),
+	}
+}
+
+// Checks the values of the group comparison flags.
+func (f *groupTestFlags) check() error {
+	if *f.fmeta == "" {
+		return fmt.Errorf("please provide a metadata file with -m")
+	}
+	if *f.column == "" {
+		return fmt.Errorf("please provide a grouping column with -g")
+	}
+	if *f.nperm < 0 {
+		return fmt.Errorf("bad number of permutations: %d", *f.nperm)
+	}
+	if *f.nt < 1 {
+		return fmt.Errorf("bad number of threads: %d", *f.nt)
+	}
+	return nil
+}
+
+// Reads the distances and the sample groups according to the flags.
+func (f *groupTestFlags) read() (d []float64, groups []int, names []string,
+	err error) {
+	fmt.Fprintln(os.Stderr, "Reading distances")
+	d, n, err := readDistances(*f.fin)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	fmt.Fprintln(os.Stderr, "Found", n, "samples")
+	ids, err := readSampleIDs(*f.fnames, n)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	groups, names, err = readGroups(*f.fmeta, *f.column, ids)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	fmt.Fprintln(os.Stderr, "Found", len(names), "groups")
+	return d, groups, names, nil
+}
+
+// Runs the permanova command.
+func permanovaMain(args []string) error {
+	fs := newFlagSet("permanova", permanovaUsage)
+	flags := addGroupTestFlags(fs)
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(0)
+	}
+	fs.Parse(args)
+	if err := flags.check(); err != nil {
+		return err
+	}
+	d, groups, names, err := flags.read()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "Running permutations")
+	d2 := make([]float64, len(d))
+	for i := range d {
+		d2[i] = d[i] * d[i]
+	}
+	stat := func(groups []int) float64 {
+		return pseudoF(d2, groups, len(names))
+	}
+	f, p := permutationTest(groups, stat, *flags.nperm, *flags.seed,
+		*flags.nt)
+
+	w, err := openOutput(*flags.fout)
+	if err != nil {
+		return err
+	}
+	res := &testResult{"PERMANOVA", "pseudo-F", len(groups), len(names),
+		f, p, *flags.nperm}
+	if err := res.write(w); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// Returns PERMANOVA's pseudo-F statistic for the given squared distances in
+// flat pyramid order, and the group of each sample.
+func pseudoF(d2 []float64, groups []int, ngroups int) float64 {
+	sizes := groupSizes(groups, ngroups)
+	n := len(groups)
+	total, within := 0.0, 0.0
+	k := 0
+	for i := range n {
+		gi := groups[i]
+		for j := range i {
+			total += d2[k]
+			if groups[j] == gi {
+				within += d2[k] / float64(sizes[gi])
+			}
+			k++
+		}
+	}
+	total /= float64(n)
+	among := total - within
+	return (among / float64(ngroups-1)) / (within / float64(n-ngroups))
+}
+
+// Performs a permutation test, where stat returns the test statistic for a
+// given assignment of values to samples (such as groups). Returns the
+// statistic of the original assignment and its p-value.
+//
+// Permutations are deterministic given the seed, regardless of the number of
+// threads.
+func permutationTest(groups []int, stat func([]int) float64,
+	nperm int, seed uint64, nt int) (float64, float64) {
+	observed := stat(groups)
+	count := 0
+	ppln.NonSerial(nt,
+		ppln.RangeInput(0, nperm),
+		func(i, _ int) (bool, error) {
+			rnd := rand.New(rand.NewPCG(seed, uint64(i)))
+			perm := make([]int, len(groups))
+			copy(perm, groups)
+			rnd.Shuffle(len(perm), func(i, j int) {
+				perm[i], perm[j] = perm[j], perm[i]
+			})
+			return stat(perm) >= observed, nil
+		},
+		func(ge bool) error {
+			if ge {
+				count++
+			}
+			return nil
+		})
+	return observed, float64(count+1) / float64(nperm+1)
+}
+
+// The result of a statistical test.
+type testResult struct {
+	method   string  // Name of the test.
+	statName string  // Name of the test statistic.
+	n        int     // Number of samples.
+	ngroups  int     // Number of groups, or 0 if not applicable.
+	stat     float64 // Value of the test statistic.
+	p        float64 // P-value.
+	nperm    int     // Number of permutations.
+}
+
+// Writes the result as tab-separated key-value rows.
+func (r *testResult) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "method\t%s\n"+
+		"test statistic name\t%s\n"+
+		"sample size\t%d\n",
+		r.method, r.statName, r.n)
+	if err != nil {
+		return err
+	}
+	if r.ngroups > 0 {
+		_, err := fmt.Fprintf(w, "number of groups\t%d\n", r.ngroups)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "test statistic\t%v\n"+
+		"p-value\t%v\n"+
+		"number of permutations\t%d\n",
+		r.stat, r.p, r.nperm)
+	return err
+}
+
+const permanovaUsage = `Tests whether sample groups differ in their centroids (PERMANOVA), on a
+distance matrix in frcfrc's output format.
+
+Usage:
+stst permanova [PARAMS]
+
+Params:`
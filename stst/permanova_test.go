@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPseudoF(t *testing.T) {
+	d := []float64{1, 4, 4, 4, 4, 1}
+	var d2 []float64
+	for _, x := range d {
+		d2 = append(d2, x*x)
+	}
+	groups := []int{0, 0, 1, 1}
+	want := 31.0
+	if got := pseudoF(d2, groups, 2); !near(got, want) {
+		t.Fatalf("pseudoF(%v,%v)=%v, want %v", d2, groups, got, want)
+	}
+}
+
+func TestOneWayF(t *testing.T) {
+	x := []float64{1, 2, 3, 5, 6, 7}
+	groups := []int{0, 0, 0, 1, 1, 1}
+	want := 24.0
+	if got := oneWayF(x, groups, 2); !near(got, want) {
+		t.Fatalf("oneWayF(%v,%v)=%v, want %v", x, groups, got, want)
+	}
+}
+
+func TestPermutationTest(t *testing.T) {
+	x := []float64{1, 2, 3, 4, 50, 60, 70, 80}
+	groups := []int{0, 0, 0, 0, 1, 1, 1, 1}
+	stat := func(groups []int) float64 {
+		return oneWayF(x, groups, 2)
+	}
+	_, p1 := permutationTest(groups, stat, 99, 1, 1)
+	_, p4 := permutationTest(groups, stat, 99, 1, 4)
+	if p1 != p4 {
+		t.Fatalf("permutationTest(...) p=%v with 1 thread, %v with 4",
+			p1, p4)
+	}
+	// Only 2 out of 70 groupings are as extreme.
+	if p1 > 0.1 {
+		t.Fatalf("permutationTest(...)=%v, want <0.1", p1)
+	}
+}
+
+func TestCentroidDists(t *testing.T) {
+	// Points on a line: 0,2 in group 0 and 10,14 in group 1.
+	points := []float64{0, 2, 10, 14}
+	var d []float64
+	for i := range points {
+		for j := range i {
+			d = append(d, points[i]-points[j])
+		}
+	}
+	groups := []int{0, 0, 1, 1}
+	c := newCentered(d, len(points))
+	vals, vecs := fullSolver(c)
+	got := centroidDists(vals, vecs, minEigenvalue(c.trace()), groups, 2)
+	want := []float64{1, 1, 2, 2}
+	if !nearVec(got, want) {
+		t.Fatalf("centroidDists(%v)=%v, want %v", points, got, want)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+)
+
+// Runs the permdisp command.
+func permdispMain(args []string) error {
+	fs := newFlagSet("permdisp", permdispUsage)
+	flags := addGroupTestFlags(fs)
+	solver := fs.String("s", "auto", "Eigen solver: full, random or auto "+
+		"(full up to "+fmt.Sprint(maxFullSolverSamples)+" samples)")
+	naxes := fs.Int("d", 50, "Number of axes to use with the random solver")
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(0)
+	}
+	fs.Parse(args)
+	if err := flags.check(); err != nil {
+		return err
+	}
+	if *naxes < 1 {
+		return fmt.Errorf("bad number of axes: %d", *naxes)
+	}
+	d, groups, names, err := flags.read()
+	if err != nil {
+		return err
+	}
+	solve, err := newSolver(*solver, len(groups), *naxes, *flags.seed,
+		*flags.nt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "Calculating distances to centroids")
+	c := newCentered(d, len(groups))
+	vals, vecs := solve(c)
+	z := centroidDists(vals, vecs, minEigenvalue(c.trace()), groups,
+		len(names))
+
+	fmt.Fprintln(os.Stderr, "Running permutations")
+	stat := func(groups []int) float64 {
+		return oneWayF(z, groups, len(names))
+	}
+	f, p := permutationTest(groups, stat, *flags.nperm, *flags.seed,
+		*flags.nt)
+
+	w, err := openOutput(*flags.fout)
+	if err != nil {
+		return err
+	}
+	res := &testResult{"PERMDISP", "F-value", len(groups), len(names),
+		f, p, *flags.nperm}
+	if err := res.write(w); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// Returns the distance of each sample from its group's centroid in principal
+// coordinate space, given the eigen decomposition of the centered matrix.
+// Axes with negative eigenvalues are subtracted, as in Anderson (2006).
+func centroidDists(vals []float64, vecs [][]float64, minVal float64,
+	groups []int, ngroups int) []float64 {
+	sizes := groupSizes(groups, ngroups)
+	dists := make([]float64, len(groups))
+	centroids := make([]float64, ngroups)
+	for a := range vals {
+		if math.Abs(vals[a]) <= minVal {
+			continue
+		}
+		sq := math.Sqrt(math.Abs(vals[a]))
+		clear(centroids)
+		for i, g := range groups {
+			centroids[g] += vecs[a][i] * sq / float64(sizes[g])
+		}
+		for i, g := range groups {
+			diff := vecs[a][i]*sq - centroids[g]
+			if vals[a] > 0 {
+				dists[i] += diff * diff
+			} else {
+				dists[i] -= diff * diff
+			}
+		}
+	}
+	for i := range dists {
+		dists[i] = math.Sqrt(math.Abs(dists[i]))
+	}
+	return dists
+}
+
+// Returns the one-way ANOVA F statistic of the given values and the group of
+// each value.
+func oneWayF(x []float64, groups []int, ngroups int) float64 {
+	sizes := groupSizes(groups, ngroups)
+	means := make([]float64, ngroups)
+	mean := 0.0
+	for i, g := range groups {
+		means[g] += x[i] / float64(sizes[g])
+		mean += x[i] / float64(len(x))
+	}
+	among, within := 0.0, 0.0
+	for g := range means {
+		diff := means[g] - mean
+		among += diff * diff * float64(sizes[g])
+	}
+	for i, g := range groups {
+		diff := x[i] - means[g]
+		within += diff * diff
+	}
+	return (among / float64(ngroups-1)) /
+		(within / float64(len(x)-ngroups))
+}
+
+const permdispUsage = `Tests whether sample groups differ in their dispersions (PERMDISP), on a
+distance matrix in frcfrc's output format.
+
+Usage:
+stst permdisp [PARAMS]
+
+Params:`
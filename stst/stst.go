@@ -0,0 +1,108 @@
+// Command stst runs statistical analyses on frcfrc's distance matrices.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/fluhus/frackyfrac/common"
+	"github.com/fluhus/gostuff/aio"
+)
+
+// A subcommand of stst.
+type command struct {
+	run  func(args []string) error // Parses the arguments and runs.
+	desc string                    // One line description, for usage.
+}
+
+// All the available subcommands.
+var commands = map[string]command{
+	"mantel":    {mantelMain, "Correlation between two matrices (Mantel)"},
+	"pcoa":      {pcoaMain, "Principal coordinates analysis"},
+	"permanova": {permanovaMain, "Group centroid differences (PERMANOVA)"},
+	"permdisp":  {permdispMain, "Group dispersion differences (PERMDISP)"},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		common.ExitIfError(fmt.Errorf("unknown command: %q", os.Args[1]))
+	}
+	common.ExitIfError(cmd.run(os.Args[2:]))
+	fmt.Fprintln(os.Stderr, "Done")
+}
+
+// Creates a flag set for the given subcommand.
+func newFlagSet(name, usageMessage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), usageMessage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Opens the given file, or stdin if empty.
+func openInput(file string) (io.ReadCloser, error) {
+	if file != "" {
+		return aio.Open(file)
+	} else {
+		return os.Stdin, nil
+	}
+}
+
+// Creates the given file, or returns stdout if empty.
+func openOutput(file string) (io.WriteCloser, error) {
+	if file != "" {
+		return aio.Create(file)
+	} else {
+		return os.Stdout, nil
+	}
+}
+
+// Reads a flat pyramid of distances from the given file, or stdin if empty.
+// Returns the distances and the number of samples.
+func readDistances(file string) ([]float64, int, error) {
+	r, err := openInput(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+	d, err := common.ReadDistances(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := common.NumElements(len(d))
+	if err != nil {
+		return nil, 0, err
+	}
+	return d, n, nil
+}
+
+// Prints usage help message.
+func usage() {
+	fmt.Fprintln(os.Stderr, `StatsyStats runs statistical analyses on frcfrc's output.
+
+Usage:
+stst COMMAND [PARAMS]
+
+Commands:`)
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].desc)
+	}
+	fmt.Fprintln(os.Stderr, `
+Run 'stst COMMAND' for the command's parameters.`)
+}
@@ -0,0 +1,105 @@
+package trees
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"iter"
+	"path/filepath"
+	"strings"
+
+	"github.com/fluhus/biostuff/formats/newick"
+	"github.com/fluhus/gostuff/aio"
+)
+
+// Supported tree file formats.
+const (
+	Newick = iota
+	Nexus
+	PhyloXML
+	JPlace
+)
+
+// Number of bytes to look at when guessing a file's format.
+const sniffSize = 512
+
+// File iterates over the trees in the given file. The format is determined by
+// the file's extension, or by its content if the extension is not known.
+// Supports newick, NEXUS, PhyloXML and jplace, where jplace queries are grafted
+// at their best placements.
+func File(file string) iter.Seq2[*newick.Node, error] {
+	return func(yield func(*newick.Node, error) bool) {
+		f, err := aio.Open(file)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer f.Close()
+		for n, err := range Reader(f, FileFormat(file)) {
+			if !yield(n, err) {
+				return
+			}
+		}
+	}
+}
+
+// Reader iterates over the trees in the given reader, in the given format.
+// A negative format means that the format is guessed from the content.
+func Reader(r io.Reader, format int) iter.Seq2[*newick.Node, error] {
+	if format < 0 {
+		br := bufio.NewReader(r)
+		head, _ := br.Peek(sniffSize)
+		r = br
+		format = formatFromContent(head)
+	}
+	switch format {
+	case Nexus:
+		return NexusReader(r)
+	case PhyloXML:
+		return PhyloXMLReader(r)
+	case JPlace:
+		return func(yield func(*newick.Node, error) bool) {
+			j, err := ReadJPlace(r)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			tree, _ := j.Graft(false)
+			yield(tree, nil)
+		}
+	default:
+		return newick.Reader(r)
+	}
+}
+
+// FileFormat returns the tree format according to the file's extension, or -1
+// if not known. Compression extensions are ignored.
+func FileFormat(file string) int {
+	ext := strings.ToLower(filepath.Ext(file))
+	switch ext {
+	case ".gz", ".bz2", ".zst":
+		return FileFormat(strings.TrimSuffix(file, filepath.Ext(file)))
+	case ".nex", ".nexus", ".nxs":
+		return Nexus
+	case ".xml", ".phyloxml":
+		return PhyloXML
+	case ".jplace":
+		return JPlace
+	case ".nwk", ".newick", ".tree", ".tre", ".treefile":
+		return Newick
+	default:
+		return -1
+	}
+}
+
+// Returns the tree format according to the beginning of the file's content.
+func formatFromContent(head []byte) int {
+	head = bytes.TrimSpace(head)
+	if len(head) >= 6 && strings.EqualFold(string(head[:6]), "#nexus") {
+		return Nexus
+	}
+	if len(head) > 0 && head[0] == '<' {
+		return PhyloXML
+	}
+	return Newick
+}
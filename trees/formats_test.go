@@ -0,0 +1,211 @@
+package trees
+
+import (
+	"fmt"
+	"iter"
+	"strings"
+	"testing"
+
+	"github.com/fluhus/biostuff/formats/newick"
+)
+
+// Returns the newick text of each tree in the input, or fails the test.
+func readAllTrees(t *testing.T, trees iter.Seq2[*newick.Node, error],
+) []string {
+	var result []string
+	for tree, err := range trees {
+		if err != nil {
+			t.Fatalf("failed to read trees: %v", err)
+		}
+		text, _ := tree.MarshalText()
+		result = append(result, string(text))
+	}
+	return result
+}
+
+func TestNexusReader(t *testing.T) {
+	input := `#NEXUS
+[Written by some tool]
+BEGIN TAXA;
+	DIMENSIONS NTAX=3;
+	TAXLABELS a_1 'b c' d;
+END;
+BEGIN TREES;
+	TRANSLATE
+		1 a_1,
+		2 'b c',
+		3 'd;e';
+	TREE tree1 = [&R] ((1:1[&rate=0.5],2:2):3,3:4);
+	tree tree2 = ((3[&x=1],1),2);
+END;
+BEGIN trees;
+	tree other = (a,b);
+END;`
+	got := readAllTrees(t, NexusReader(strings.NewReader(input)))
+	want := []string{
+		"((a_1:1,b_c:2):3,'d;e':4);",
+		"(('d;e',a_1),b_c);",
+		"(a,b);",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("NexusReader()=%v, want %v", got, want)
+	}
+}
+
+func TestNexusReader_bad(t *testing.T) {
+	inputs := []string{
+		"BEGIN TREES; tree a = (a,b); END;",
+		"#NEXUS BEGIN TREES; tree a = (a,b",
+		"#NEXUS BEGIN TREES; tree a = (a,b)); END;",
+		"#NEXUS BEGIN TREES; tree (a,b); END;",
+	}
+	for _, input := range inputs {
+		for _, err := range NexusReader(strings.NewReader(input)) {
+			if err == nil {
+				t.Errorf("NexusReader(%q) succeeded, want error", input)
+			}
+		}
+	}
+}
+
+func TestPhyloXMLReader(t *testing.T) {
+	input := `<?xml version="1.0" encoding="UTF-8"?>
+<phyloxml xmlns="http://www.phyloxml.org">
+  <phylogeny rooted="true">
+    <name>first</name>
+    <clade>
+      <clade branch_length="3">
+        <clade><name>a</name><branch_length>1</branch_length></clade>
+        <clade>
+          <taxonomy><scientific_name>b c</scientific_name></taxonomy>
+          <branch_length>2</branch_length>
+        </clade>
+      </clade>
+      <clade><name>d</name><branch_length>4</branch_length></clade>
+    </clade>
+  </phylogeny>
+  <phylogeny rooted="false">
+    <clade><clade><name>x</name></clade><clade><name>y</name></clade></clade>
+  </phylogeny>
+</phyloxml>`
+	got := readAllTrees(t, PhyloXMLReader(strings.NewReader(input)))
+	want := []string{"((a:1,b_c:2):3,d:4);", "(x,y);"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("PhyloXMLReader()=%v, want %v", got, want)
+	}
+}
+
+func TestReader_sniff(t *testing.T) {
+	inputs := []string{
+		"((a:1,b:2):3,c:4);",
+		"\n  #nexus\nbegin trees; tree t = ((a:1,b:2):3,c:4); end;",
+		"<phyloxml><phylogeny><clade>" +
+			"<clade><branch_length>3</branch_length>" +
+			"<clade><name>a</name><branch_length>1</branch_length></clade>" +
+			"<clade><name>b</name><branch_length>2</branch_length></clade>" +
+			"</clade>" +
+			"<clade><name>c</name><branch_length>4</branch_length></clade>" +
+			"</clade></phylogeny></phyloxml>",
+	}
+	want := "((a:1,b:2):3,c:4);"
+	for _, input := range inputs {
+		got := readAllTrees(t, Reader(strings.NewReader(input), -1))
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("Reader(%q)=%v, want [%v]", input, got, want)
+		}
+	}
+}
+
+func TestFormatFromName(t *testing.T) {
+	tests := []struct {
+		file string
+		want int
+	}{
+		{"a.nex", Nexus},
+		{"a.trees.NEXUS.gz", Nexus},
+		{"a.xml.zst", PhyloXML},
+		{"a.nwk", Newick},
+		{"a.trees", -1},
+		{"a.gz", -1},
+		{"a.jplace.bz2", JPlace},
+	}
+	for _, test := range tests {
+		if got := FileFormat(test.file); got != test.want {
+			t.Errorf("FileFormat(%q)=%v, want %v", test.file, got,
+				test.want)
+		}
+	}
+}
+
+func TestGraft(t *testing.T) {
+	input := `{
+  "tree": "((a:1{0},b:2{1}):3{2},c:4{3}){4};",
+  "fields": ["edge_num", "likelihood", "like_weight_ratio",
+    "distal_length", "pendant_length"],
+  "placements": [
+    {"p": [[0, -10, 0.8, 0.25, 0.5], [3, -11, 0.2, 1, 0.1]], "n": ["x"]},
+    {"p": [[0, -10, 1, 0.5, 0.2]], "nm": [["y", 3], ["z", 1]]}
+  ],
+  "version": 3
+}`
+	j, err := ReadJPlace(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ReadJPlace() failed: %v", err)
+	}
+
+	tree, split := j.Graft(false)
+	got, _ := tree.MarshalText()
+	want := "(((((a:0.25,x:0.5):0.25,y:0.2),z:0.2):0.5,b:2):3,c:4);"
+	if string(got) != want {
+		t.Errorf("Graft(false)=%s, want %s", got, want)
+	}
+	if len(split["x"]) != 1 || split["x"][0] != (Fraction{"x", 1}) {
+		t.Errorf("Graft(false) split[x]=%v, want [{x 1}]", split["x"])
+	}
+
+	tree, split = j.Graft(true)
+	got, _ = tree.MarshalText()
+	want = "(((((a:0.25,x#1:0.5):0.25,y#1:0.2),z#1:0.2):0.5,b:2):3," +
+		"(c:1,x#2:0.1):3);"
+	if string(got) != want {
+		t.Errorf("Graft(true)=%s, want %s", got, want)
+	}
+	wantSplit := []Fraction{{"x#1", 0.8}, {"x#2", 0.2}}
+	if fmt.Sprint(split["x"]) != fmt.Sprint(wantSplit) {
+		t.Errorf("Graft(true) split[x]=%v, want %v", split["x"], wantSplit)
+	}
+}
+
+func TestReadJPlace_unnumberedRoot(t *testing.T) {
+	input := `{"tree": "((a:1{0},b:2{1}):3{2},c:4{3});",
+	  "fields": ["edge_num", "distal_length", "pendant_length"],
+	  "placements": [{"p": [[3, 1, 0.5]], "n": ["x"]}]}`
+	j, err := ReadJPlace(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ReadJPlace() failed: %v", err)
+	}
+	tree, _ := j.Graft(false)
+	got, _ := tree.MarshalText()
+	want := "((a:1,b:2):3,(c:1,x:0.5):3);"
+	if string(got) != want {
+		t.Errorf("Graft(false)=%s, want %s", got, want)
+	}
+}
+
+func TestReadJPlace_bad(t *testing.T) {
+	inputs := []string{
+		`{"tree": "(a:1{0},b:2{1}){2};", "fields": ["edge_num"],
+		  "placements": []}`,
+		`{"tree": "(a:1{0},b:2);",
+		  "fields": ["edge_num", "distal_length", "pendant_length"],
+		  "placements": []}`,
+		`{"tree": "(a:1{0},b:2{1}){2};",
+		  "fields": ["edge_num", "distal_length", "pendant_length"],
+		  "placements": [{"p": [[5, 0, 0]], "n": ["x"]}]}`,
+	}
+	for _, input := range inputs {
+		if _, err := ReadJPlace(strings.NewReader(input)); err == nil {
+			t.Errorf("ReadJPlace(%q) succeeded, want error", input)
+		}
+	}
+}
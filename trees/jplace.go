@@ -0,0 +1,267 @@
+package trees
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fluhus/biostuff/formats/newick"
+	"github.com/fluhus/gostuff/aio"
+)
+
+// PlacedTree is a reference tree with query placements, as produced by tree
+// placement tools such as pplacer and EPA-ng.
+type PlacedTree struct {
+	Tree    *newick.Node // Reference tree.
+	Queries []*Query     // Placed queries.
+
+	edges map[int]*newick.Node // Node under each edge number.
+}
+
+// Query is a set of identical query sequences and their possible placements.
+type Query struct {
+	Names      []string     // Names of the sequences.
+	Placements []*Placement // Possible placements.
+}
+
+// Placement is a possible location of a query on the reference tree.
+type Placement struct {
+	Edge    int     // Edge number in the reference tree.
+	Weight  float64 // Likelihood weight ratio.
+	Distal  float64 // Distance from the placement to the edge's lower node.
+	Pendant float64 // Length of the branch leading to the query.
+}
+
+// Fraction is a share of a query's abundance that goes to a grafted leaf.
+type Fraction struct {
+	Name   string  // Name of the grafted leaf.
+	Weight float64 // Share of the query's abundance.
+}
+
+// A jplace file's JSON structure.
+type jplaceJSON struct {
+	Tree       string   `json:"tree"`
+	Fields     []string `json:"fields"`
+	Placements []struct {
+		P  [][]float64 `json:"p"`
+		N  []string    `json:"n"`
+		NM [][2]any    `json:"nm"`
+	} `json:"placements"`
+}
+
+// ReadJPlaceFile reads a jplace file.
+func ReadJPlaceFile(file string) (*PlacedTree, error) {
+	f, err := aio.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadJPlace(f)
+}
+
+// ReadJPlace reads jplace JSON from the given reader.
+func ReadJPlace(r io.Reader) (*PlacedTree, error) {
+	var raw jplaceJSON
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+	result := &PlacedTree{}
+	var err error
+	result.Tree, result.edges, err = parseEdgeNumbers(raw.Tree)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]int{}
+	for i, f := range raw.Fields {
+		fields[f] = i
+	}
+	for _, f := range []string{"edge_num", "distal_length", "pendant_length"} {
+		if _, ok := fields[f]; !ok {
+			return nil, fmt.Errorf("placements have no %q field", f)
+		}
+	}
+	lwr, hasLWR := fields["like_weight_ratio"]
+
+	for _, p := range raw.Placements {
+		q := &Query{Names: p.N}
+		for _, nm := range p.NM {
+			name, ok := nm[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("bad query name: %v", nm[0])
+			}
+			q.Names = append(q.Names, name)
+		}
+		if len(q.Names) == 0 {
+			return nil, fmt.Errorf("found placements with no query names")
+		}
+		for _, values := range p.P {
+			if len(values) != len(raw.Fields) {
+				return nil, fmt.Errorf("query %q: placement has %d values, "+
+					"want %d", q.Names[0], len(values), len(raw.Fields))
+			}
+			pl := &Placement{
+				Edge:    int(values[fields["edge_num"]]),
+				Weight:  1,
+				Distal:  values[fields["distal_length"]],
+				Pendant: values[fields["pendant_length"]],
+			}
+			if hasLWR {
+				pl.Weight = values[lwr]
+			}
+			if _, ok := result.edges[pl.Edge]; !ok {
+				return nil, fmt.Errorf("query %q: edge %d is not in the tree",
+					q.Names[0], pl.Edge)
+			}
+			q.Placements = append(q.Placements, pl)
+		}
+		if len(q.Placements) == 0 {
+			return nil, fmt.Errorf("query %q has no placements", q.Names[0])
+		}
+		result.Queries = append(result.Queries, q)
+	}
+	return result, nil
+}
+
+// Parses a newick tree whose nodes are followed by edge numbers in braces.
+// The root may have no edge number, since it has no edge above it. Returns the
+// tree and the node under each edge number.
+func parseEdgeNumbers(s string) (*newick.Node, map[int]*newick.Node, error) {
+	// Edge numbers are attached to the ends of nodes, which appear in
+	// post-order.
+	var nums []int
+	stripped := &strings.Builder{}
+	quote := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\'':
+			quote = !quote
+		case !quote && s[i] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end == -1 {
+				return nil, nil, fmt.Errorf("unterminated edge number")
+			}
+			num, err := strconv.Atoi(s[i+1 : i+end])
+			if err != nil {
+				return nil, nil, fmt.Errorf("bad edge number: %v", err)
+			}
+			nums = append(nums, num)
+			i += end
+			continue
+		}
+		stripped.WriteByte(s[i])
+	}
+
+	var tree *newick.Node
+	for t, err := range newick.Reader(strings.NewReader(stripped.String())) {
+		if err != nil {
+			return nil, nil, err
+		}
+		tree = t
+		break
+	}
+	if tree == nil {
+		return nil, nil, fmt.Errorf("jplace has no tree")
+	}
+	nodes := 0
+	for range tree.PostOrder() {
+		nodes++
+	}
+	if len(nums) != nodes && len(nums) != nodes-1 {
+		return nil, nil, fmt.Errorf("found %d edge numbers, want one per "+
+			"node (%d), or one per node except the root", len(nums), nodes)
+	}
+	edges := map[int]*newick.Node{}
+	i := 0
+	for n := range tree.PostOrder() {
+		if i == len(nums) { // Unnumbered root, which is last.
+			break
+		}
+		if _, ok := edges[nums[i]]; ok {
+			return nil, nil, fmt.Errorf("duplicate edge number: %d", nums[i])
+		}
+		edges[nums[i]] = n
+		i++
+	}
+	return tree, edges, nil
+}
+
+// Graft returns a copy of the reference tree with the queries added as
+// leaves. If fractional is false, each query is grafted at its placement with
+// the highest weight, with its own names. Otherwise, each query is grafted at
+// all of its placements, with leaves named NAME#1, NAME#2...
+//
+// Also returns the leaves that each query name was grafted as, and the share
+// of the query's abundance that each leaf should get.
+func (j *PlacedTree) Graft(fractional bool) (*newick.Node,
+	map[string][]Fraction) {
+	// Grafts on each edge.
+	type graft struct {
+		distal  float64
+		pendant float64
+		name    string
+	}
+	grafts := map[*newick.Node][]graft{}
+	split := map[string][]Fraction{}
+	for _, q := range j.Queries {
+		placements := q.Placements
+		if !fractional {
+			best := slices.MaxFunc(placements, func(a, b *Placement) int {
+				return cmp.Compare(a.Weight, b.Weight)
+			})
+			placements = []*Placement{best}
+		}
+		total := 0.0
+		for _, p := range placements {
+			total += p.Weight
+		}
+		for _, name := range q.Names {
+			for i, p := range placements {
+				leaf := name
+				if fractional {
+					leaf = fmt.Sprintf("%s#%d", name, i+1)
+				}
+				w := 1 / float64(len(placements))
+				if total > 0 {
+					w = p.Weight / total
+				}
+				node := j.edges[p.Edge]
+				grafts[node] = append(grafts[node],
+					graft{p.Distal, p.Pendant, leaf})
+				split[name] = append(split[name], Fraction{leaf, w})
+			}
+		}
+	}
+
+	// Copy the tree, adding the grafts above their nodes.
+	var copyNode func(n *newick.Node) *newick.Node
+	copyNode = func(n *newick.Node) *newick.Node {
+		result := &newick.Node{Name: n.Name, Distance: n.Distance}
+		for _, c := range n.Children {
+			result.Children = append(result.Children, copyNode(c))
+		}
+		g := grafts[n]
+		if len(g) == 0 {
+			return result
+		}
+		slices.SortStableFunc(g, func(a, b graft) int {
+			return cmp.Compare(a.distal, b.distal)
+		})
+		cur, pos := result, 0.0 // Top node so far and its distance from n.
+		for _, gr := range g {
+			distal := max(min(gr.distal, n.Distance), pos)
+			cur.Distance = distal - pos
+			cur = &newick.Node{Children: []*newick.Node{
+				cur, {Name: gr.name, Distance: gr.pendant},
+			}}
+			pos = distal
+		}
+		cur.Distance = max(n.Distance-pos, 0)
+		return cur
+	}
+	return copyNode(j.Tree), split
+}
@@ -0,0 +1,209 @@
+package trees
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+	"unicode"
+
+	"github.com/fluhus/biostuff/formats/newick"
+)
+
+// NexusReader iterates over the trees in the TREES blocks of a NEXUS input.
+// Leaf names are translated according to the block's TRANSLATE command, if
+// present. Comments, such as BEAST's node annotations, are ignored.
+func NexusReader(r io.Reader) iter.Seq2[*newick.Node, error] {
+	return func(yield func(*newick.Node, error) bool) {
+		br := bufio.NewReader(r)
+		inTrees := false
+		var translate map[string]string
+		first := true
+		for {
+			cmd, err := nextNexusCommand(br)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if first {
+				first = false
+				if len(cmd) < 6 || !strings.EqualFold(cmd[:6], "#nexus") {
+					yield(nil, fmt.Errorf("input does not start with #NEXUS"))
+					return
+				}
+				cmd = strings.TrimSpace(cmd[6:])
+			}
+			keyword, rest := splitKeyword(cmd)
+			switch strings.ToLower(keyword) {
+			case "begin":
+				inTrees = strings.EqualFold(rest, "trees")
+				translate = nil
+			case "end", "endblock":
+				inTrees = false
+			case "translate":
+				if !inTrees {
+					break
+				}
+				translate, err = parseTranslate(rest)
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+			case "tree", "utree":
+				if !inTrees {
+					break
+				}
+				tree, err := parseNexusTree(rest, translate)
+				if !yield(tree, err) || err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Reads the next semicolon-terminated command, without comments and
+// surrounding whitespace. Returns io.EOF if no more commands are available.
+func nextNexusCommand(r *bufio.Reader) (string, error) {
+	buf := &bytes.Buffer{}
+	quote := byte(0)
+	comment := 0 // Comment depth, comments may be nested.
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err != io.EOF {
+				return "", err
+			}
+			if quote != 0 || comment > 0 {
+				return "", io.ErrUnexpectedEOF
+			}
+			if s := strings.TrimSpace(buf.String()); s != "" {
+				// The #NEXUS header is not terminated by a semicolon.
+				if strings.EqualFold(s, "#nexus") {
+					return s, nil
+				}
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", io.EOF
+		}
+		switch {
+		case comment > 0:
+			switch b {
+			case '[':
+				comment++
+			case ']':
+				comment--
+			}
+		case quote != 0:
+			if b == quote {
+				quote = 0
+			}
+			buf.WriteByte(b)
+		case b == '[':
+			comment++
+		case b == '\'' || b == '"':
+			quote = b
+			buf.WriteByte(b)
+		case b == ';':
+			return strings.TrimSpace(buf.String()), nil
+		default:
+			buf.WriteByte(b)
+		}
+	}
+}
+
+// Splits a command into its first word and the rest of it.
+func splitKeyword(cmd string) (string, string) {
+	i := strings.IndexFunc(cmd, unicode.IsSpace)
+	if i == -1 {
+		return cmd, ""
+	}
+	return cmd[:i], strings.TrimSpace(cmd[i:])
+}
+
+// Parses the arguments of a TRANSLATE command into a map from token to
+// taxon name.
+func parseTranslate(s string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, entry := range splitUnquoted(s, ',') {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value := splitKeyword(entry)
+		if value == "" {
+			return nil, fmt.Errorf("bad TRANSLATE entry: %q", entry)
+		}
+		result[nexusName(key)] = nexusName(value)
+	}
+	return result, nil
+}
+
+// Parses the arguments of a TREE command, of the form "name = newick".
+func parseNexusTree(s string, translate map[string]string,
+) (*newick.Node, error) {
+	parts := splitUnquoted(s, '=')
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("bad TREE command: %q", s)
+	}
+	text := strings.Join(parts[1:], "=") + ";"
+	var tree *newick.Node
+	for t, err := range newick.Reader(strings.NewReader(text)) {
+		if err != nil {
+			return nil, fmt.Errorf("tree %s: %v",
+				strings.TrimSpace(parts[0]), err)
+		}
+		tree = t
+		break
+	}
+	if tree == nil {
+		return nil, fmt.Errorf("tree %s is empty", strings.TrimSpace(parts[0]))
+	}
+	if translate != nil {
+		for n := range tree.PreOrder() {
+			if len(n.Children) > 0 {
+				continue
+			}
+			if name, ok := translate[n.Name]; ok {
+				n.Name = name
+			}
+		}
+	}
+	return tree, nil
+}
+
+// Splits s at each occurrence of sep outside quotes.
+func splitUnquoted(s string, sep byte) []string {
+	var result []string
+	quote := byte(0)
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '\'' || s[i] == '"':
+			quote = s[i]
+		case s[i] == sep:
+			result = append(result, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(result, s[start:])
+}
+
+// Converts a possibly quoted NEXUS name to a regular string, the same way
+// newick names are converted.
+func nexusName(s string) string {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		q := s[:1]
+		return strings.ReplaceAll(s[1:len(s)-1], q+q, q)
+	}
+	return strings.ReplaceAll(s, "_", " ")
+}
@@ -0,0 +1,98 @@
+package trees
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"iter"
+	"strconv"
+	"strings"
+
+	"github.com/fluhus/biostuff/formats/newick"
+)
+
+// A clade element in PhyloXML.
+type xmlClade struct {
+	Name         string `xml:"name"`
+	BranchLength string `xml:"branch_length"`
+	LengthAttr   string `xml:"branch_length,attr"`
+	Taxonomy     []struct {
+		ScientificName string `xml:"scientific_name"`
+	} `xml:"taxonomy"`
+	Clades []*xmlClade `xml:"clade"`
+}
+
+// A phylogeny element in PhyloXML.
+type xmlPhylogeny struct {
+	Name  string    `xml:"name"`
+	Clade *xmlClade `xml:"clade"`
+}
+
+// PhyloXMLReader iterates over the phylogenies in a PhyloXML input.
+// Clades without a name are named after their taxonomy's scientific name,
+// if present.
+func PhyloXMLReader(r io.Reader) iter.Seq2[*newick.Node, error] {
+	return func(yield func(*newick.Node, error) bool) {
+		dec := xml.NewDecoder(r)
+		for {
+			token, err := dec.Token()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			start, ok := token.(xml.StartElement)
+			if !ok || start.Name.Local != "phylogeny" {
+				continue
+			}
+			var p xmlPhylogeny
+			if err := dec.DecodeElement(&p, &start); err != nil {
+				yield(nil, err)
+				return
+			}
+			if p.Clade == nil {
+				yield(nil, fmt.Errorf("phylogeny %q has no clades", p.Name))
+				return
+			}
+			tree, err := p.Clade.toNode()
+			if !yield(tree, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Converts the clade to a tree node.
+func (c *xmlClade) toNode() (*newick.Node, error) {
+	node := &newick.Node{Name: strings.TrimSpace(c.Name)}
+	if node.Name == "" {
+		for _, t := range c.Taxonomy {
+			if name := strings.TrimSpace(t.ScientificName); name != "" {
+				node.Name = name
+				break
+			}
+		}
+	}
+	length := strings.TrimSpace(c.BranchLength)
+	if length == "" {
+		length = strings.TrimSpace(c.LengthAttr)
+	}
+	if length != "" {
+		var err error
+		node.Distance, err = strconv.ParseFloat(length, 64)
+		if err != nil {
+			return nil, fmt.Errorf("clade %q: bad branch length: %v",
+				node.Name, err)
+		}
+	}
+	for _, child := range c.Clades {
+		n, err := child.toNode()
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, n)
+	}
+	return node, nil
+}
@@ -0,0 +1,292 @@
+package trees
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/fluhus/biostuff/formats/newick"
+)
+
+// IsUnrooted returns whether the tree looks unrooted, meaning that its top
+// node has more than 2 children.
+func IsUnrooted(tree *newick.Node) bool {
+	return len(tree.Children) > 2
+}
+
+// An indexed view of a tree, for traversing it in every direction.
+type indexed struct {
+	nodes  []*newick.Node // Nodes in pre-order.
+	parent []int          // Index of each node's parent, -1 for the root.
+	size   []int          // Number of nodes in each subtree.
+	leaves []int          // Indexes of the leaves.
+}
+
+// Returns an indexed view of the given tree.
+func newIndexed(tree *newick.Node) *indexed {
+	t := &indexed{}
+	idx := map[*newick.Node]int{}
+	for n := range tree.PreOrder() {
+		idx[n] = len(t.nodes)
+		t.nodes = append(t.nodes, n)
+	}
+	t.parent = make([]int, len(t.nodes))
+	t.size = make([]int, len(t.nodes))
+	t.parent[0] = -1
+	for i, n := range t.nodes {
+		for _, c := range n.Children {
+			t.parent[idx[c]] = i
+		}
+		if len(n.Children) == 0 {
+			t.leaves = append(t.leaves, i)
+		}
+	}
+	for i := len(t.nodes) - 1; i >= 0; i-- {
+		t.size[i]++
+		if t.parent[i] != -1 {
+			t.size[t.parent[i]] += t.size[i]
+		}
+	}
+	return t
+}
+
+// Returns whether node i is in the subtree of node j.
+func (t *indexed) isUnder(i, j int) bool {
+	return i >= j && i < j+t.size[j]
+}
+
+// Returns the distances from node i to all nodes, and the index of the next
+// node on the path from each node to i (-1 for i itself).
+func (t *indexed) distsFrom(i int) ([]float64, []int) {
+	dists := make([]float64, len(t.nodes))
+	next := make([]int, len(t.nodes))
+	next[i] = -1
+	stack := []int{i}
+	visited := make([]bool, len(t.nodes))
+	visited[i] = true
+	for len(stack) > 0 {
+		u := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		visit := func(v int, length float64) {
+			if visited[v] {
+				return
+			}
+			visited[v] = true
+			dists[v] = dists[u] + length
+			next[v] = u
+			stack = append(stack, v)
+		}
+		if p := t.parent[u]; p != -1 {
+			visit(p, t.nodes[u].Distance)
+		}
+		for c := u + 1; c < u+t.size[u]; c += t.size[c] {
+			visit(c, t.nodes[c].Distance)
+		}
+	}
+	return dists, next
+}
+
+// Returns the farthest leaf in the given distances.
+func (t *indexed) farthestLeaf(dists []float64) int {
+	result := t.leaves[0]
+	for _, l := range t.leaves {
+		if dists[l] > dists[result] {
+			result = l
+		}
+	}
+	return result
+}
+
+// RootAt returns a copy of the tree, rerooted on the branch above the given
+// node, at distance x from that node. Former roots with a single child are
+// merged with it.
+func RootAt(tree, node *newick.Node, x float64) *newick.Node {
+	t := newIndexed(tree)
+	for i, n := range t.nodes {
+		if n == node {
+			return t.rootAt(i, x)
+		}
+	}
+	panic("node is not in the tree")
+}
+
+// Returns a copy of the tree, rerooted on the branch above node i, at
+// distance x from i.
+func (t *indexed) rootAt(i int, x float64) *newick.Node {
+	if i == 0 {
+		panic("cannot root above the root")
+	}
+	length := t.nodes[i].Distance
+	x = max(min(x, length), 0)
+	root := &newick.Node{}
+	root.Children = []*newick.Node{
+		t.copyFrom(i, t.parent[i], x),
+		t.copyFrom(t.parent[i], i, length-x),
+	}
+	return root
+}
+
+// Returns a copy of the subtree of node i when coming from node from,
+// with the given distance from its new parent.
+func (t *indexed) copyFrom(i, from int, dist float64) *newick.Node {
+	node := &newick.Node{Name: t.nodes[i].Name, Distance: dist}
+	if p := t.parent[i]; p != -1 && p != from {
+		node.Children = append(node.Children,
+			t.copyFrom(p, i, t.nodes[i].Distance))
+	}
+	for c := i + 1; c < i+t.size[i]; c += t.size[c] {
+		if c != from {
+			node.Children = append(node.Children,
+				t.copyFrom(c, i, t.nodes[c].Distance))
+		}
+	}
+	if len(node.Children) == 1 {
+		node.Children[0].Distance += dist
+		return node.Children[0]
+	}
+	return node
+}
+
+// Returns a copy of the tree, rooted on the point on the path between u and
+// v, at distance x from u.
+func (t *indexed) rootOnPath(u, v int, x float64) *newick.Node {
+	_, next := t.distsFrom(v)
+	for w := u; w != v; w = next[w] {
+		nw := next[w]
+		length := t.nodes[w].Distance
+		if t.parent[w] != nw { // Going down the tree.
+			length = t.nodes[nw].Distance
+		}
+		if x <= length {
+			if t.parent[w] == nw {
+				return t.rootAt(w, x)
+			}
+			return t.rootAt(nw, length-x)
+		}
+		x -= length
+	}
+	panic(fmt.Sprintf("distance %v exceeds the path's length", x))
+}
+
+// MidpointRoot returns a copy of the tree, rooted at the middle of the
+// longest path between two leaves.
+func MidpointRoot(tree *newick.Node) (*newick.Node, error) {
+	t := newIndexed(tree)
+	if len(t.leaves) < 2 {
+		return nil, fmt.Errorf("cannot root a tree with less than 2 leaves")
+	}
+	d, _ := t.distsFrom(t.leaves[0])
+	a := t.farthestLeaf(d)
+	d, _ = t.distsFrom(a)
+	b := t.farthestLeaf(d)
+	return t.rootOnPath(a, b, d[b]/2), nil
+}
+
+// OutgroupRoot returns a copy of the tree, rooted at the middle of the branch
+// that separates the leaves with the given names from the rest of the tree.
+// Returns an error if no such branch exists.
+func OutgroupRoot(tree *newick.Node, names []string) (*newick.Node, error) {
+	t := newIndexed(tree)
+	want := map[string]bool{}
+	for _, name := range names {
+		want[name] = true
+	}
+	inGroup := make([]int, len(t.nodes)) // Outgroup leaves under each node.
+	nLeaves := make([]int, len(t.nodes)) // Leaves under each node.
+	found := map[string]bool{}
+	for _, l := range t.leaves {
+		if want[t.nodes[l].Name] {
+			found[t.nodes[l].Name] = true
+			for i := l; i != -1; i = t.parent[i] {
+				inGroup[i]++
+			}
+		}
+		for i := l; i != -1; i = t.parent[i] {
+			nLeaves[i]++
+		}
+	}
+	for _, name := range names {
+		if !found[name] {
+			return nil, fmt.Errorf("outgroup leaf %q is not in the tree", name)
+		}
+	}
+	n := inGroup[0]
+	if n == len(t.leaves) {
+		return nil, fmt.Errorf("outgroup contains all the leaves")
+	}
+	for i := 1; i < len(t.nodes); i++ {
+		if (inGroup[i] == n && nLeaves[i] == n) ||
+			(inGroup[i] == 0 && nLeaves[i] == len(t.leaves)-n) {
+			return t.rootAt(i, t.nodes[i].Distance/2), nil
+		}
+	}
+	return nil, fmt.Errorf("outgroup is not a clade in the tree")
+}
+
+// MADRoot returns a copy of the tree, rooted using minimal ancestor deviation
+// (Tria et al. 2017).
+//
+// Works in O(n^3) time and O(n^2) memory, where n is the number of leaves.
+func MADRoot(tree *newick.Node) (*newick.Node, error) {
+	t := newIndexed(tree)
+	if len(t.leaves) < 3 {
+		return nil, fmt.Errorf("MAD rooting needs at least 3 leaves, got %d",
+			len(t.leaves))
+	}
+	dists := make([][]float64, len(t.leaves)) // Leaf to node distances.
+	for i, l := range t.leaves {
+		dists[i], _ = t.distsFrom(l)
+	}
+
+	best, bestX, bestDev := -1, 0.0, math.Inf(1)
+	for c := 1; c < len(t.nodes); c++ {
+		b := t.parent[c]
+		length := t.nodes[c].Distance
+		// Sums for the pairs that cross the branch.
+		s0, s1, s2 := 0.0, 0.0, 0.0
+		// Sum for the other pairs.
+		same := 0.0
+		npairs := 0
+		for i, li := range t.leaves {
+			for j := range i {
+				lj := t.leaves[j]
+				dij := dists[i][lj]
+				if dij == 0 {
+					continue
+				}
+				npairs++
+				underI, underJ := t.isUnder(li, c), t.isUnder(lj, c)
+				if underI != underJ {
+					// Root at distance x from b: dev=(2a+2x-d)/d.
+					a := dists[i][b]
+					if underI {
+						a = dists[j][b]
+					}
+					dev := (2*a - dij) / dij
+					s0 += dev * dev
+					s1 += dev / dij
+					s2 += 1 / (dij * dij)
+					continue
+				}
+				end := b
+				if underI {
+					end = c
+				}
+				anc := (dists[i][end] + dij - dists[j][end]) / 2
+				dev := 2*anc/dij - 1
+				same += dev * dev
+			}
+		}
+		x := 0.0
+		if s2 > 0 {
+			x = max(min(-s1/(2*s2), length), 0)
+		}
+		dev := same + s0 + 4*x*s1 + 4*x*x*s2
+		if npairs > 0 {
+			dev = math.Sqrt(dev / float64(npairs))
+		}
+		if dev < bestDev {
+			best, bestX, bestDev = c, x, dev
+		}
+	}
+	return t.rootAt(best, t.nodes[best].Distance-bestX), nil
+}
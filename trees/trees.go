@@ -0,0 +1,48 @@
+// Package trees provides manipulations of phylogenetic trees.
+package trees
+
+import (
+	"github.com/fluhus/biostuff/formats/newick"
+)
+
+// Prune returns a copy of the tree with only the leaves for which keep
+// returns true. Nodes that are left with a single child are merged with it,
+// adding up their branch lengths. Returns nil if no leaves are kept.
+func Prune(tree *newick.Node, keep func(*newick.Node) bool) *newick.Node {
+	if len(tree.Children) == 0 {
+		if !keep(tree) {
+			return nil
+		}
+		return &newick.Node{Name: tree.Name, Distance: tree.Distance}
+	}
+	var children []*newick.Node
+	for _, c := range tree.Children {
+		if cc := Prune(c, keep); cc != nil {
+			children = append(children, cc)
+		}
+	}
+	switch len(children) {
+	case 0:
+		return nil
+	case 1:
+		children[0].Distance += tree.Distance
+		return children[0]
+	default:
+		return &newick.Node{
+			Name:     tree.Name,
+			Distance: tree.Distance,
+			Children: children,
+		}
+	}
+}
+
+// Leaves returns the number of leaves in the tree.
+func Leaves(tree *newick.Node) int {
+	count := 0
+	for n := range tree.PreOrder() {
+		if len(n.Children) == 0 {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,154 @@
+package trees
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fluhus/biostuff/formats/newick"
+)
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		tree string
+		keep []string
+		want string
+	}{
+		{"((a:1,b:2):3,(c:4,d:5):6);", []string{"a", "b", "c", "d"},
+			"((a:1,b:2):3,(c:4,d:5):6);"},
+		{"((a:1,b:2):3,(c:4,d:5):6);", []string{"a", "c", "d"},
+			"(a:4,(c:4,d:5):6);"},
+		{"((a:1,b:2):3,(c:4,d:5):6);", []string{"a", "c"},
+			"(a:4,c:10);"},
+		{"((a:1,b:2):3,(c:4,d:5):6)r:1;", []string{"c", "d"},
+			"(c:4,d:5):7;"},
+		{"((a:1,b:2):3,(c:4,d:5):6);", []string{"d"}, "d:11;"},
+	}
+	for _, test := range tests {
+		tree, err := parseTree(test.tree)
+		if err != nil {
+			t.Fatalf("failed to parse tree %q: %v", test.tree, err)
+		}
+		keep := map[string]bool{}
+		for _, name := range test.keep {
+			keep[name] = true
+		}
+		pruned := Prune(tree, func(n *newick.Node) bool {
+			return keep[n.Name]
+		})
+		got, _ := pruned.MarshalText()
+		if string(got) != test.want {
+			t.Errorf("Prune(%q,%v)=%q, want %q",
+				test.tree, test.keep, got, test.want)
+		}
+	}
+}
+
+func TestPrune_none(t *testing.T) {
+	tree, _ := parseTree("((a:1,b:2):3,c:4);")
+	if got := Prune(tree, func(*newick.Node) bool {
+		return false
+	}); got != nil {
+		t.Fatalf("Prune(...)=%v, want nil", got)
+	}
+}
+
+func parseTree(s string) (*newick.Node, error) {
+	for tr, err := range newick.Reader(strings.NewReader(s)) {
+		return tr, err
+	}
+	return nil, fmt.Errorf("no tree provided")
+}
+
+func TestMidpointRoot(t *testing.T) {
+	tests := []struct {
+		tree string
+		want string
+	}{
+		{"((a:1,b:2):1,c:10);", "(c:6.5,(a:1,b:2):4.5);"},
+		{"(a:1,b:1,c:4);", "(c:2.5,(a:1,b:1):1.5);"},
+	}
+	for _, test := range tests {
+		tree, err := parseTree(test.tree)
+		if err != nil {
+			t.Fatalf("failed to parse tree %q: %v", test.tree, err)
+		}
+		rooted, err := MidpointRoot(tree)
+		if err != nil {
+			t.Fatalf("MidpointRoot(%q) failed: %v", test.tree, err)
+		}
+		got, _ := rooted.MarshalText()
+		if string(got) != test.want {
+			t.Errorf("MidpointRoot(%q)=%q, want %q", test.tree, got, test.want)
+		}
+	}
+}
+
+func TestOutgroupRoot(t *testing.T) {
+	tests := []struct {
+		tree     string
+		outgroup []string
+		want     string
+	}{
+		{"((a:1,b:2):1,c:3);", []string{"a"}, "(a:0.5,(c:4,b:2):0.5);"},
+		{"((a:1,b:2):1,(c:3,d:4):2);", []string{"c", "d"},
+			"((a:1,b:2):0.5,(c:3,d:4):2.5);"},
+		{"(a:1,b:2,(c:3,d:4):2);", []string{"a", "b"},
+			"((c:3,d:4):1,(a:1,b:2):1);"},
+	}
+	for _, test := range tests {
+		tree, err := parseTree(test.tree)
+		if err != nil {
+			t.Fatalf("failed to parse tree %q: %v", test.tree, err)
+		}
+		rooted, err := OutgroupRoot(tree, test.outgroup)
+		if err != nil {
+			t.Fatalf("OutgroupRoot(%q,%v) failed: %v",
+				test.tree, test.outgroup, err)
+		}
+		got, _ := rooted.MarshalText()
+		if string(got) != test.want {
+			t.Errorf("OutgroupRoot(%q,%v)=%q, want %q",
+				test.tree, test.outgroup, got, test.want)
+		}
+	}
+}
+
+func TestOutgroupRoot_bad(t *testing.T) {
+	tests := []struct {
+		tree     string
+		outgroup []string
+	}{
+		{"((a:1,b:2):1,(c:3,d:4):2);", []string{"a", "c"}},
+		{"((a:1,b:2):1,(c:3,d:4):2);", []string{"e"}},
+		{"((a:1,b:2):1,c:3);", []string{"a", "b", "c"}},
+	}
+	for _, test := range tests {
+		tree, err := parseTree(test.tree)
+		if err != nil {
+			t.Fatalf("failed to parse tree %q: %v", test.tree, err)
+		}
+		if got, err := OutgroupRoot(tree, test.outgroup); err == nil {
+			t.Errorf("OutgroupRoot(%q,%v)=%v, want error",
+				test.tree, test.outgroup, got)
+		}
+	}
+}
+
+func TestMADRoot(t *testing.T) {
+	// An unrooted version of ((a:1,b:1):2,(c:1.5,d:1.5):1.5).
+	treeText := "(a:1,b:1,(c:1.5,d:1.5):3.5);"
+	want := "((c:1.5,d:1.5):1.5,(a:1,b:1):2);"
+	tree, err := parseTree(treeText)
+	if err != nil {
+		t.Fatalf("failed to parse tree %q: %v", treeText, err)
+	}
+	rooted, err := MADRoot(tree)
+	if err != nil {
+		t.Fatalf("MADRoot(%q) failed: %v", treeText, err)
+	}
+	got, _ := rooted.MarshalText()
+	if string(got) != want {
+		t.Errorf("MADRoot(%q)=%q, want %q", treeText, got, want)
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fluhus/biostuff/formats/newick"
+	"github.com/fluhus/frackyfrac/trees"
+	"github.com/fluhus/gostuff/jio"
+	"github.com/fluhus/gostuff/minhash"
+	"github.com/fluhus/gostuff/ppln"
+	"github.com/fluhus/gostuff/ptimer"
+)
+
+const (
+	// Name of the index file in a sketch database directory.
+	dbIndexFile = "db.json"
+
+	// Name of the sketch subdirectory in a sketch database directory.
+	dbSketchDir = "sketches"
+
+	// Name of the stored distances file in a sketch database directory.
+	// Holds float32 little-endian values in flat pyramid order.
+	dbDistFile = "distances.bin"
+
+	// Name of the stored tree file in a sketch database directory.
+	dbTreeFile = "tree.nwk"
+)
+
+// A persistent store of sketches in a directory. Sketches are keyed by the
+// content hash of their fasta files, and all share the same parameters.
+type sketchDB struct {
+	dir string
+	sketchParams
+	Genomes   []dbGenome `json:"genomes"`
+	Distances int        `json:"distances,omitempty"` // Genomes in distances.
+	Metric    string     `json:"metric,omitempty"`    // Type of distances.
+	Tree      int        `json:"tree,omitempty"`      // Genomes in the tree.
+}
+
+// Sketching parameters. Zero values mean unset.
+type sketchParams struct {
+	K      uint `json:"k"`
+	N      uint `json:"n,omitempty"`      // Bottom-n sketch size.
+	Scaled uint `json:"scaled,omitempty"` // FracMinHash scale, instead of n.
+	Seed   uint `json:"seed,omitempty"`   // Hash seed.
+
+	// Sequence type, empty for dna.
+	Molecule string `json:"molecule,omitempty"`
+
+	// Minimal k-mer count, where 0 and 1 keep all k-mers.
+	MinCount uint `json:"min_count,omitempty"`
+
+	// Whether DNA k-mers are cleaned up like in sourmash and Mash.
+	Compat bool `json:"compat,omitempty"`
+}
+
+// Returns the sequence type.
+func (p sketchParams) molecule() string {
+	if p.Molecule == "" {
+		return "dna"
+	}
+	return p.Molecule
+}
+
+// Returns whether p matches the wanted parameters, where unset ones match
+// any value.
+func (p sketchParams) matches(want sketchParams) bool {
+	return (want.K == 0 || want.K == p.K) &&
+		(want.N == 0 || want.N == p.N) &&
+		(want.Scaled == 0 || want.Scaled == p.Scaled) &&
+		(want.Seed == 0 || want.Seed == p.Seed) &&
+		(want.Molecule == "" || want.Molecule == p.molecule()) &&
+		(want.MinCount == 0 || max(want.MinCount, 1) == max(p.MinCount, 1)) &&
+		(!want.Compat || p.Compat)
+}
+
+// Returns the set parameters, like "k=21 n=1000".
+func (p sketchParams) String() string {
+	var parts []string
+	for _, x := range []struct {
+		name  string
+		value uint
+	}{{"k", p.K}, {"n", p.N}, {"scaled", p.Scaled}, {"seed", p.Seed},
+		{"min-count", p.MinCount}} {
+		if x.value != 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", x.name, x.value))
+		}
+	}
+	if p.Molecule != "" {
+		parts = append(parts, "molecule="+p.Molecule)
+	}
+	if p.Compat {
+		parts = append(parts, "compat")
+	}
+	return strings.Join(parts, " ")
+}
+
+// A genome in a sketch database.
+type dbGenome struct {
+	Name string `json:"name"` // Leaf name in the tree.
+	Hash string `json:"hash"` // Content hash of the fasta file.
+}
+
+// Opens the sketch database in the given directory. If create is true and the
+// directory has no database, creates an empty one with the wanted parameters,
+// where n defaults to the one in the arguments. A database with parameters
+// other than the wanted ones is rejected.
+func openDB(dir string, want sketchParams, create bool) (*sketchDB, error) {
+	db := &sketchDB{dir: dir}
+	err := jio.Read(filepath.Join(dir, dbIndexFile), db)
+	if os.IsNotExist(err) {
+		if !create {
+			return nil, fmt.Errorf("no sketch database in %q, create one "+
+				"with 'trtr sketch'", dir)
+		}
+		if want.K == 0 {
+			return nil, fmt.Errorf("please provide a kmer length with -k")
+		}
+		if want.Scaled != 0 && want.N != 0 {
+			return nil, fmt.Errorf("cannot use both n and scaled sketches")
+		}
+		db.sketchParams = want
+		if want.Molecule == "dna" {
+			db.Molecule = ""
+		}
+		if want.MinCount == 1 {
+			db.MinCount = 0
+		}
+		if want.Scaled == 0 {
+			db.N = orDefault(want.N, *n)
+		}
+		if err := os.MkdirAll(db.sketchDir(), 0o755); err != nil {
+			return nil, err
+		}
+		return db, db.save()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading sketch database: %v", err)
+	}
+	if !db.matches(want) {
+		return nil, fmt.Errorf("sketch database in %q has %v, which does "+
+			"not match the requested %v", dir, db.sketchParams, want)
+	}
+	return db, nil
+}
+
+// Writes the database's index file.
+func (db *sketchDB) save() error {
+	tmp := filepath.Join(db.dir, dbIndexFile+".tmp")
+	if err := jio.Write(tmp, db); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(db.dir, dbIndexFile))
+}
+
+// Returns the directory of the sketch files.
+func (db *sketchDB) sketchDir() string {
+	return filepath.Join(db.dir, dbSketchDir)
+}
+
+// Returns the path of the sketch of the file with the given hash.
+func (db *sketchDB) sketchPath(hash string) string {
+	return filepath.Join(db.sketchDir(), hash+".json.gz")
+}
+
+// Adds the given fasta files to the database and returns their genomes, in the
+// order of the files. Files whose content is already in the database are not
+// sketched again, except for files split by -split.
+func (db *sketchDB) addFiles(files []string) ([]dbGenome, error) {
+	known := map[string]string{}
+	for _, g := range db.Genomes {
+		known[g.Name] = g.Hash
+	}
+	pt := ptimer.NewMessage("{} files sketched")
+	var result []dbGenome
+	err := ppln.Serial(*nt,
+		ppln.SliceInput(files),
+		func(file string, _, _ int) ([]dbGenome, error) {
+			hash, err := fileHash(file)
+			if err != nil {
+				return nil, err
+			}
+			if !isSketchFile(file) && *split != "file" {
+				return db.addSplit(file, hash)
+			}
+			if !isSketchFile(file) {
+				g := dbGenome{Name: filepath.Base(file), Hash: hash}
+				if _, err := os.Stat(db.sketchPath(hash)); err == nil {
+					return []dbGenome{g}, nil
+				}
+				mh, err := sketchFasta(file)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", file, err)
+				}
+				return []dbGenome{g}, db.store(mh, hash)
+			}
+
+			// A sketch file's sketches are keyed by the file's hash and their
+			// position in it.
+			sketches, err := readSketchFile(file)
+			if err != nil {
+				return nil, err
+			}
+			var gs []dbGenome
+			for i, s := range sketches {
+				g := dbGenome{Name: s.name, Hash: fmt.Sprintf("%s-%d", hash, i+1)}
+				if err := db.store(s.mh, g.Hash); err != nil {
+					return nil, err
+				}
+				gs = append(gs, g)
+			}
+			return gs, nil
+		},
+		func(gs []dbGenome) error {
+			for _, g := range gs {
+				if hash, ok := known[g.Name]; ok && hash != g.Hash {
+					return fmt.Errorf("genome %q is already in the database "+
+						"with different content", g.Name)
+				} else if !ok {
+					db.Genomes = append(db.Genomes, g)
+					known[g.Name] = g.Hash
+				}
+				result = append(result, g)
+			}
+			pt.Inc()
+			return nil
+		})
+	pt.Done()
+	if err != nil {
+		return nil, err
+	}
+	return result, db.save()
+}
+
+// Adds the leaves of a fasta file that is split by -split, and returns their
+// genomes. A leaf's sketch is keyed by the file's hash and the names of the
+// leaf's records, so that another split of the file gives other keys.
+func (db *sketchDB) addSplit(file, hash string) ([]dbGenome, error) {
+	sketches, records, err := splitSketches(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	var gs []dbGenome
+	for i, s := range sketches {
+		g := dbGenome{
+			Name: s.name,
+			Hash: hash + "-" + strhash(strings.Join(records[i], "\n")),
+		}
+		if _, err := os.Stat(db.sketchPath(g.Hash)); err != nil {
+			if err := db.store(s.mh, g.Hash); err != nil {
+				return nil, err
+			}
+		}
+		gs = append(gs, g)
+	}
+	return gs, nil
+}
+
+// Stores the given sketch in the database, under the given hash.
+func (db *sketchDB) store(mh *minhash.MinHash[uint64], hash string) error {
+	// Write to a temporary file first, so that an interrupted run does not
+	// leave a partial sketch behind.
+	f, err := os.CreateTemp(db.sketchDir(), "tmp-*.json.gz")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err := jio.Write(f.Name(), mh); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), db.sketchPath(hash))
+}
+
+// Loads the sketches of the given genomes.
+func (db *sketchDB) loadSketches(genomes []dbGenome,
+) ([]*minhash.MinHash[uint64], error) {
+	files := make([]string, len(genomes))
+	for i, g := range genomes {
+		files[i] = db.sketchPath(g.Hash)
+	}
+	return loadSketches(files)
+}
+
+// Returns the content hash of the given file.
+func fileHash(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(h.Sum(nil))[:26], nil
+}
+
+// Returns x, or def if x is 0.
+func orDefault(x, def uint) uint {
+	if x == 0 {
+		return def
+	}
+	return x
+}
+
+// Returns the distances of the genomes from index from onwards to the genomes
+// before them, in flat pyramid order. If cache is true, the distances are
+// stored in the database and only the ones of newly added genomes are
+// computed. Otherwise only the requested distances are computed.
+func (db *sketchDB) distanceRows(sketches []*minhash.MinHash[uint64],
+	from int, cache bool) ([]float64, error) {
+	if len(sketches) != len(db.Genomes) {
+		panic(fmt.Sprintf("mismatching lengths: %d, %d",
+			len(sketches), len(db.Genomes)))
+	}
+	if !cache {
+		return sketchDistanceRows(sketches, from), nil
+	}
+	if db.Metric != *metric { // Stored distances are of another type.
+		db.Distances, db.Metric = 0, *metric
+	}
+
+	// Drop values that were written by an interrupted run.
+	file := filepath.Join(db.dir, dbDistFile)
+	stored := db.Distances
+	if err := os.Truncate(file, int64(pairs(stored)*4)); err != nil &&
+		!os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var result []float64
+	if from < stored {
+		var err error
+		result, err = readFloat32s(file, pairs(from), pairs(stored))
+		if err != nil {
+			return nil, fmt.Errorf("reading stored distances: %v", err)
+		}
+	}
+	if stored < len(sketches) {
+		fmt.Fprintln(os.Stderr, "Computing distances of",
+			len(sketches)-stored, "new genomes")
+		added := sketchDistanceRows(sketches, stored)
+		if err := appendFloat32s(file, added); err != nil {
+			return nil, err
+		}
+		db.Distances = len(sketches)
+		if err := db.save(); err != nil {
+			return nil, err
+		}
+		if from > stored {
+			added = added[pairs(from)-pairs(stored):]
+		}
+		result = append(result, added...)
+	}
+	return result, nil
+}
+
+// Reads the stored tree, which has the first db.Tree genomes as leaves.
+func (db *sketchDB) readTree() (*newick.Node, error) {
+	file := filepath.Join(db.dir, dbTreeFile)
+	for tree, err := range trees.File(file) {
+		return tree, err
+	}
+	return nil, fmt.Errorf("%s: no tree found", file)
+}
+
+// Stores the given tree, which has all the genomes as leaves.
+func (db *sketchDB) writeTree(tree *newick.Node) error {
+	text, err := tree.MarshalText()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(db.dir, dbTreeFile),
+		append(text, '\n'), 0o644); err != nil {
+		return err
+	}
+	db.Tree = len(db.Genomes)
+	return db.save()
+}
+
+// Returns the number of pairs of n elements.
+func pairs(n int) int {
+	return n * (n - 1) / 2
+}
+
+// Reads float32 values from the given file, from index start to end.
+func readFloat32s(file string, start, end int) ([]float64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(int64(start*4), io.SeekStart); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	result := make([]float64, end-start)
+	var buf [4]byte
+	for i := range result {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		result[i] = float64(math.Float32frombits(
+			binary.LittleEndian.Uint32(buf[:])))
+	}
+	return result, nil
+}
+
+// Appends the given values to the given file as float32.
+func appendFloat32s(file string, x []float64) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	var buf [4]byte
+	for _, v := range x {
+		binary.LittleEndian.PutUint32(buf[:], math.Float32bits(float32(v)))
+		if _, err := w.Write(buf[:]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
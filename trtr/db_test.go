@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSketchDB(t *testing.T) {
+	dir := t.TempDir()
+	fa1 := filepath.Join(dir, "a.fa")
+	fa2 := filepath.Join(dir, "b.fa")
+	if err := os.WriteFile(fa1, []byte(">a\nACGTTGCAAGGCT\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fa2, []byte(">b\nTTGCAAGGCTACG\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dbDir := filepath.Join(dir, "db")
+
+	if _, err := openDB(dbDir, sketchParams{K: 5, N: 10}, false); err == nil {
+		t.Fatalf("openDB(%q) succeeded on a missing database, want error",
+			dbDir)
+	}
+	db, err := openDB(dbDir, sketchParams{K: 5, N: 10}, true)
+	if err != nil {
+		t.Fatalf("openDB(%q) failed: %v", dbDir, err)
+	}
+	defer func(k0, n0 uint) { *k, *n = k0, n0 }(*k, *n)
+	*k, *n = db.K, db.N
+	if _, err := db.addFiles([]string{fa1}); err != nil {
+		t.Fatalf("addFiles(%q) failed: %v", fa1, err)
+	}
+	sketch1, err := os.Stat(db.sketchPath(db.Genomes[0].Hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = openDB(dbDir, sketchParams{}, false)
+	if err != nil {
+		t.Fatalf("openDB(%q) failed: %v", dbDir, err)
+	}
+	got, err := db.addFiles([]string{fa2, fa1})
+	if err != nil {
+		t.Fatalf("addFiles(%q,%q) failed: %v", fa2, fa1, err)
+	}
+	var names []string
+	for _, g := range got {
+		names = append(names, g.Name)
+	}
+	if want := []string{"b.fa", "a.fa"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("addFiles(%q,%q) names=%v, want %v", fa2, fa1, names, want)
+	}
+	if len(db.Genomes) != 2 {
+		t.Fatalf("len(Genomes)=%d, want 2", len(db.Genomes))
+	}
+	again, err := os.Stat(db.sketchPath(got[1].Hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.ModTime().Equal(sketch1.ModTime()) {
+		t.Errorf("addFiles(%q) sketched an existing file again", fa1)
+	}
+
+	if _, err := openDB(dbDir, sketchParams{K: 6}, false); err == nil {
+		t.Errorf("openDB(%q, k=6) succeeded, want error", dbDir)
+	}
+	if _, err := openDB(dbDir, sketchParams{K: 5, N: 11}, false); err == nil {
+		t.Errorf("openDB(%q, n=11) succeeded, want error", dbDir)
+	}
+}
@@ -6,8 +6,7 @@ import (
 	"os"
 
 	"github.com/fluhus/biostuff/formats/newick"
-	"github.com/fluhus/frackyfrac/common"
-	"github.com/fluhus/gostuff/clustering"
+	"github.com/fluhus/frackyfrac/trees"
 	"github.com/fluhus/gostuff/gnum"
 	"github.com/fluhus/gostuff/minhash"
 	"github.com/fluhus/gostuff/ppln"
@@ -36,62 +35,126 @@ type deepNode struct {
 	children []*deepNode
 }
 
-// Creates a tree from the given sketches with names as the leaf names.
-func makeTree(sketches []*minhash.MinHash[uint64], names []string) *newick.Node {
+// Creates a tree from the given sketches with names as the leaf names, using
+// the tree building method in the arguments.
+func makeTree(sketches []*minhash.MinHash[uint64], names []string,
+) (*newick.Node, error) {
 	if len(sketches) != len(names) {
 		panic(fmt.Sprintf("mismatching lengths: %d, %d",
 			len(sketches), len(names)))
 	}
+	return treeFromDistances(sketchDistances(sketches), names)
+}
+
+// Creates a tree from the given distances in flat pyramid order with names as
+// the leaf names, using the tree building method in the arguments.
+func treeFromDistances(distances []float64, names []string,
+) (*newick.Node, error) {
+	if len(distances) > 0 {
+		fmt.Fprintf(os.Stderr, "Distances: [%.2f,%.2f] mean=%.2f+-%.2f\n",
+			gnum.Min(distances), gnum.Max(distances), gnum.Mean(distances),
+			gnum.Std(distances))
+	}
+	if reportEntropy {
+		fmt.Fprintf(os.Stderr, "Entropy=%.2f\n", entropy(distances))
+	}
+	var tree *newick.Node
+	switch *method {
+	case "nj", "fastnj":
+		tree = neighborJoining(distances, names, *method == "fastnj")
+		if len(names) > 1 {
+			var err error
+			tree, err = trees.MidpointRoot(tree)
+			if err != nil {
+				return nil, err
+			}
+		}
+	default:
+		tree = aggloTree(distances, names, *linkage)
+	}
+	if *fit {
+		fmt.Fprintln(os.Stderr, "Fitting branch lengths")
+		if err := fitBranchLengths(tree, distances, names,
+			*fitIters); err != nil {
+			return nil, err
+		}
+	}
+	if *distOut != "" {
+		fmt.Fprintln(os.Stderr, "Writing distances")
+		err := writeDistanceMatrix(*distOut, distances, names,
+			*distFormat == "square")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tree, nil
+}
+
+// Returns the Mash distances between the given sketches, in flat pyramid
+// order.
+func sketchDistances(sketches []*minhash.MinHash[uint64]) []float64 {
+	return sketchDistanceRows(sketches, 0)
+}
+
+// Returns the Mash distances of each sketch from index from onwards to the
+// sketches before it, in flat pyramid order. These are the rows that follow
+// the distances between the first from sketches.
+func sketchDistanceRows(sketches []*minhash.MinHash[uint64], from int,
+) []float64 {
 	var distances []float64
 	ppln.Serial[[2]*minhash.MinHash[uint64], float64](
 		*nt,
-		common.IterPairs(sketches),
+		func(yield func([2]*minhash.MinHash[uint64], error) bool) {
+			for i := from; i < len(sketches); i++ {
+				for j := range i {
+					if !yield([2]*minhash.MinHash[uint64]{
+						sketches[i], sketches[j]}, nil) {
+						return
+					}
+				}
+			}
+		},
 		func(a [2]*minhash.MinHash[uint64], i, g int) (float64, error) {
-			return jaccardToMash(a[0].Jaccard(a[1])), nil
+			return sketchDistance(a[0], a[1]), nil
 		},
 		func(a float64) error {
 			distances = append(distances, a)
 			return nil
 		},
 	)
-	hcl := clustering.Agglo(len(sketches), clustering.AggloAverage,
-		func(i, j int) float64 {
-			if i == j {
-				return 0
-			}
-			return distances[ijToN(i, j)]
-		})
-	fmt.Printf("Ditances: [%.2f,%.2f] mean=%.2f+-%.2f\n",
-		gnum.Min(distances), gnum.Max(distances), gnum.Mean(distances),
-		gnum.Std(distances))
-	if reportEntropy {
-		fmt.Fprintf(os.Stderr, "Entropy=%.2f\n", entropy(distances))
-	}
+	return distances
+}
+
+// Creates a tree from the given distances in flat pyramid order with names as
+// the leaf names, using agglomerative clustering with the given linkage.
+func aggloTree(distances []float64, names []string, linkage string,
+) *newick.Node {
 	var nodes []*deepNode
 	for _, name := range names {
 		nodes = append(nodes, &deepNode{name: name})
 	}
-	for i := 0; i < hcl.Len(); i++ {
-		step := hcl.Step(i)
+	for _, step := range aggloSteps(distances, len(names), linkage) {
 		node1, node2 := nodes[step.C1], nodes[step.C2]
-		depth := step.D / 2 // Divided by the 2 children.
-		// TODO(amit): Consider dividing new depth relative to each child node's
-		// depth.
+		// Each child is at half the distance between the clusters, but not
+		// below the children, so that branch lengths are not negative.
+		depth := max(step.D/2, node1.depth, node2.depth)
 		parent := &deepNode{children: []*deepNode{node1, node2}, depth: depth}
 		nodes[step.C2] = parent
 	}
 	return nodes[len(nodes)-1].toNewickNode()
 }
 
-// Converts coordinates in the distance matrix to an index in the linear vector.
-func ijToN(i, j int) int {
-	if i == j {
-		panic(fmt.Sprintf("i=j is not allowed (i=j=%v)", i))
-	}
-	if i < j {
-		i, j = j, i
+// Returns the distance between the given sketches, by the sketch type and
+// distance in the arguments.
+func sketchDistance(a, b *minhash.MinHash[uint64]) float64 {
+	switch {
+	case *scaled == 0:
+		return jaccardToMash(a.Jaccard(b))
+	case *metric == "ani":
+		return scaledANIDistance(a, b)
+	default:
+		return scaledMashDistance(a, b)
 	}
-	return i*(i-1)/2 + j
 }
 
 // Converts a Jaccard similarity score to Mash distance.
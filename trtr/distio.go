@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/fluhus/frackyfrac/common"
+	"github.com/fluhus/gostuff/aio"
+)
+
+// Writes the distances in flat pyramid order to the given file, as a flat
+// pyramid with one value per line like frcfrc's output, or as a tab-separated
+// square matrix with the names as row and column labels.
+func writeDistanceMatrix(file string, distances []float64, names []string,
+	square bool) error {
+	f, err := aio.Create(file)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if square {
+		err = writeSquare(w, distances, names)
+	} else {
+		for _, d := range distances {
+			if _, err = fmt.Fprintln(w, d); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Writes the distances in flat pyramid order as a labeled square matrix.
+func writeSquare(w io.Writer, distances []float64, names []string) error {
+	for _, name := range names {
+		if _, err := fmt.Fprint(w, "\t", name); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(w)
+	for i, name := range names {
+		fmt.Fprint(w, name)
+		for j := range names {
+			d := 0.0
+			if i != j {
+				d = distances[common.PairIndex(i, j)]
+			}
+			fmt.Fprint(w, "\t", d)
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads a distance matrix from the given file, as written by
+// writeDistanceMatrix. A square matrix is recognized by the tabs in its first
+// line. A flat pyramid has no names, so its names are read from namesFile,
+// one per line, or are the 1-based row numbers if namesFile is empty.
+//
+// Returns the distances in flat pyramid order and the names.
+func readDistanceMatrix(file, namesFile string) ([]float64, []string,
+	error) {
+	f, err := aio.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	distances, names, err := parseDistanceMatrix(data, namesFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(names) < 2 {
+		return nil, nil, fmt.Errorf("need distances between at least 2 "+
+			"elements, got %d", len(names))
+	}
+	return distances, names, nil
+}
+
+// Parses the contents of a distance matrix file, for readDistanceMatrix.
+func parseDistanceMatrix(data []byte, namesFile string) ([]float64, []string,
+	error) {
+	first, _, _ := strings.Cut(strings.TrimLeft(string(data), "\r\n"), "\n")
+	if strings.Contains(first, "\t") {
+		if namesFile != "" {
+			return nil, nil, fmt.Errorf("a square matrix has its own names, " +
+				"no need for a names file")
+		}
+		return readSquare(bytes.NewReader(data))
+	}
+
+	distances, err := common.ReadDistances(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	n, err := common.NumElements(len(distances))
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, d := range distances {
+		if math.IsNaN(d) || d < 0 {
+			return nil, nil, fmt.Errorf("value #%d: bad distance: %v", i+1, d)
+		}
+	}
+	var names []string
+	if namesFile == "" {
+		for i := range n {
+			names = append(names, fmt.Sprint(i+1))
+		}
+	} else {
+		names, err = readNames(namesFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(names) != n {
+			return nil, nil, fmt.Errorf("got %d names for %d elements",
+				len(names), n)
+		}
+	}
+	return distances, names, nil
+}
+
+// Reads a labeled square distance matrix. The two values of each pair are
+// averaged, so asymmetric matrices are accepted.
+func readSquare(r io.Reader) ([]float64, []string, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<30)
+	var names []string
+	var rows [][]float64
+	i := 0
+	for sc.Scan() {
+		i++
+		line := strings.TrimRight(sc.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		parts := strings.Split(line, "\t")
+		if names == nil {
+			names = parts[1:]
+			continue
+		}
+		if len(parts) != len(names)+1 {
+			return nil, nil, fmt.Errorf("line #%d: has %d values, expected %d",
+				i, len(parts), len(names)+1)
+		}
+		if len(rows) == len(names) {
+			return nil, nil, fmt.Errorf("line #%d: got more rows than the "+
+				"%d columns", i, len(names))
+		}
+		if parts[0] != names[len(rows)] {
+			return nil, nil, fmt.Errorf("line #%d: row name %q does not "+
+				"match column name %q", i, parts[0], names[len(rows)])
+		}
+		row := make([]float64, len(names))
+		for j, part := range parts[1:] {
+			d, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line #%d: %v", i, err)
+			}
+			if math.IsNaN(d) || d < 0 {
+				return nil, nil, fmt.Errorf("line #%d: bad distance: %v",
+					i, d)
+			}
+			row[j] = d
+		}
+		rows = append(rows, row)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(rows) != len(names) {
+		return nil, nil, fmt.Errorf("got %d rows for %d columns",
+			len(rows), len(names))
+	}
+	var distances []float64
+	for i := range rows {
+		for j := range i {
+			distances = append(distances, (rows[i][j]+rows[j][i])/2)
+		}
+	}
+	return distances, names, nil
+}
+
+// Reads names from the given file, one per line.
+func readNames(file string) ([]string, error) {
+	f, err := aio.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var names []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if name := strings.TrimSpace(sc.Text()); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, sc.Err()
+}
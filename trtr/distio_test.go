@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDistanceMatrix(t *testing.T) {
+	dir := t.TempDir()
+	d := []float64{1, 2, 3, 4.5, 5, 6}
+	names := []string{"a", "b", "c", "d"}
+	for _, square := range []bool{false, true} {
+		file := filepath.Join(dir, "d.txt")
+		if err := writeDistanceMatrix(file, d, names, square); err != nil {
+			t.Fatalf("writeDistanceMatrix(%v) failed: %v", square, err)
+		}
+		namesFile := ""
+		if !square {
+			namesFile = filepath.Join(dir, "names.txt")
+			if err := os.WriteFile(namesFile, []byte("a\nb\nc\nd\n"),
+				0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		gotD, gotNames, err := readDistanceMatrix(file, namesFile)
+		if err != nil {
+			t.Fatalf("readDistanceMatrix(%v) failed: %v", square, err)
+		}
+		if !reflect.DeepEqual(gotD, d) || !reflect.DeepEqual(gotNames, names) {
+			t.Errorf("readDistanceMatrix(%v)=%v,%v, want %v,%v",
+				square, gotD, gotNames, d, names)
+		}
+	}
+}
+
+func TestReadSquare_asymmetric(t *testing.T) {
+	input := "\tx\ty\nx\t0\t1\ny\t3\t0\n"
+	d, names, err := readSquare(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("readSquare(%q) failed: %v", input, err)
+	}
+	if want := []float64{2}; !reflect.DeepEqual(d, want) {
+		t.Errorf("readSquare(%q)=%v, want %v", input, d, want)
+	}
+	if want := []string{"x", "y"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("readSquare(%q) names=%v, want %v", input, names, want)
+	}
+}
+
+func TestReadDistanceMatrix_bad(t *testing.T) {
+	inputs := []string{
+		"",
+		"\ta\n",
+		"\ta\na\t0\nb\t0\n",
+		"\ta\nb\t0\n",
+		"\ta\tb\na\t0\t1\n",
+		"\ta\na\t0\n",
+	}
+	file := filepath.Join(t.TempDir(), "d.txt")
+	for _, input := range inputs {
+		if err := os.WriteFile(file, []byte(input), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if d, names, err := readDistanceMatrix(file, ""); err == nil {
+			t.Errorf("readDistanceMatrix(%q)=%v,%v, want error",
+				input, d, names)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/fluhus/biostuff/formats/newick"
+)
+
+// Inserts new leaves into a tree whose leaves are the first from names. rows
+// holds the distances of each new leaf to the leaves before it, in flat
+// pyramid order, as returned by sketchDistanceRows. Each new leaf joins the
+// tree at half its distance from its nearest leaf, on the path from that leaf
+// to the root, as in a single agglomerative clustering step. Returns the new
+// root.
+func insertLeaves(tree *newick.Node, names []string, from int,
+	rows []float64) (*newick.Node, error) {
+	if from == 0 {
+		panic("cannot insert into an empty tree")
+	}
+	if len(rows) != pairs(len(names))-pairs(from) {
+		panic(fmt.Sprintf("got %d distances, want %d",
+			len(rows), pairs(len(names))-pairs(from)))
+	}
+	leaves := map[string]*newick.Node{}
+	parents := map[*newick.Node]*newick.Node{}
+	for n := range tree.PreOrder() {
+		for _, c := range n.Children {
+			parents[c] = n
+		}
+		if len(n.Children) == 0 {
+			leaves[n.Name] = n
+		}
+	}
+	for _, name := range names[:from] {
+		if leaves[name] == nil {
+			return nil, fmt.Errorf("tree has no leaf named %q", name)
+		}
+	}
+
+	for i := from; i < len(names); i++ {
+		row := rows[:i]
+		rows = rows[i:]
+		j := 0
+		for jj := range row {
+			if row[jj] < row[j] {
+				j = jj
+			}
+		}
+		near := leaves[names[j]]
+		leaf := &newick.Node{Name: names[i]}
+		leaves[names[i]] = leaf
+
+		// Walk up from the nearest leaf to half their distance, and split the
+		// branch there with a new parent.
+		h := row[j] / 2
+		node, up := near, h
+		for parents[node] != nil && node.Distance < up {
+			up -= node.Distance
+			node = parents[node]
+		}
+		parent := &newick.Node{Children: []*newick.Node{node, leaf}}
+		if p := parents[node]; p != nil {
+			k := slices.Index(p.Children, node)
+			p.Children[k] = parent
+			parents[parent] = p
+			parent.Distance = node.Distance - up
+		} else {
+			tree = parent
+		}
+		node.Distance = up
+		leaf.Distance = h
+		parents[node] = parent
+		parents[leaf] = parent
+	}
+	return tree, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fluhus/biostuff/formats/newick"
+)
+
+func TestInsertLeaves(t *testing.T) {
+	leaf := func(name string, d float64) *newick.Node {
+		return &newick.Node{Name: name, Distance: d}
+	}
+	tree := &newick.Node{Children: []*newick.Node{
+		{Children: []*newick.Node{leaf("a", 1), leaf("b", 1)}, Distance: 1},
+		leaf("c", 2),
+	}}
+	names := []string{"a", "b", "c", "d", "e", "f"}
+	rows := []float64{
+		1, 3, 5, // d
+		5, 5, 3, 5, // e
+		9, 9, 9, 9, 9, // f
+	}
+	got, err := insertLeaves(tree, names, 3, rows)
+	if err != nil {
+		t.Fatalf("insertLeaves() failed: %v", err)
+	}
+	text, _ := got.MarshalText()
+	want := "((((a:0.5,d:0.5):0.5,b:1):1,(c:1.5,e:1.5):0.5):2.5,f:4.5);"
+	if string(text) != want {
+		t.Fatalf("insertLeaves()=%s, want %s", text, want)
+	}
+}
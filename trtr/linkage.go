@@ -0,0 +1,119 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"github.com/fluhus/frackyfrac/common"
+	"github.com/fluhus/gostuff/clustering"
+)
+
+// Returns the steps of agglomerative clustering of n elements with the given
+// distances in flat pyramid order and linkage method (average, single,
+// complete or wpgma).
+func aggloSteps(distances []float64, n int, linkage string,
+) []clustering.AggloStep {
+	if linkage == "wpgma" {
+		return wpgma(distances, n)
+	}
+	var method int
+	switch linkage {
+	case "single":
+		method = clustering.AggloMin
+	case "complete":
+		method = clustering.AggloMax
+	default:
+		method = clustering.AggloAverage
+	}
+	hcl := clustering.Agglo(n, method, func(i, j int) float64 {
+		if i == j {
+			return 0
+		}
+		return distances[common.PairIndex(i, j)]
+	})
+	steps := make([]clustering.AggloStep, hcl.Len())
+	for i := range steps {
+		steps[i] = hcl.Step(i)
+	}
+	return steps
+}
+
+// Performs WPGMA clustering, where the distance of a merged cluster from
+// another cluster is the mean of its two parts' distances from it. Uses the
+// nearest-neighbor chain algorithm, in O(n^2) time.
+//
+// Returns steps with the same conventions as clustering.Agglo.
+func wpgma(distances []float64, n int) []clustering.AggloStep {
+	d := slices.Clone(distances)
+	active := make([]bool, n)
+	for i := range active {
+		active[i] = true
+	}
+
+	// Find the merges, in no particular order. Each cluster is represented by
+	// one of its elements.
+	var merges []clustering.AggloStep
+	var chain []int
+	for left := n; left > 1; {
+		if len(chain) == 0 {
+			chain = append(chain, slices.Index(active, true))
+		}
+		a := chain[len(chain)-1]
+		b, db := -1, math.Inf(1)
+		if len(chain) > 1 { // Prefer the previous element on ties.
+			b = chain[len(chain)-2]
+			db = d[common.PairIndex(a, b)]
+		}
+		for c := range n {
+			if c == a || !active[c] {
+				continue
+			}
+			if dc := d[common.PairIndex(a, c)]; dc < db {
+				b, db = c, dc
+			}
+		}
+		if len(chain) == 1 || b != chain[len(chain)-2] {
+			chain = append(chain, b)
+			continue
+		}
+
+		// a and b are reciprocal nearest neighbors, merge a into b.
+		chain = chain[:len(chain)-2]
+		merges = append(merges, clustering.AggloStep{C1: a, C2: b, D: db})
+		active[a] = false
+		left--
+		for c := range n {
+			if c != b && active[c] {
+				bc := common.PairIndex(b, c)
+				d[bc] = (d[common.PairIndex(a, c)] + d[bc]) / 2
+			}
+		}
+	}
+
+	// Sort the merges and name the clusters by their greatest element.
+	slices.SortStableFunc(merges, func(a, b clustering.AggloStep) int {
+		return cmp.Compare(a.D, b.D)
+	})
+	parent := make([]int, n) // Union-find over elements.
+	greatest := make([]int, n)
+	for i := range parent {
+		parent[i] = i
+		greatest[i] = i
+	}
+	find := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+	for i, m := range merges {
+		a, b := find(m.C1), find(m.C2)
+		ga, gb := greatest[a], greatest[b]
+		merges[i].C1, merges[i].C2 = min(ga, gb), max(ga, gb)
+		parent[a] = b
+		greatest[b] = max(ga, gb)
+	}
+	return merges
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fluhus/gostuff/clustering"
+)
+
+func TestAggloSteps(t *testing.T) {
+	d := []float64{2, 4, 6, 10, 20, 30}
+	step := func(c1, c2 int, d float64) clustering.AggloStep {
+		return clustering.AggloStep{C1: c1, C2: c2, D: d}
+	}
+	tests := []struct {
+		linkage string
+		want    []clustering.AggloStep
+	}{
+		{"wpgma", []clustering.AggloStep{
+			step(0, 1, 2), step(1, 2, 5), step(2, 3, 22.5)}},
+		{"average", []clustering.AggloStep{
+			step(0, 1, 2), step(1, 2, 5), step(2, 3, 20)}},
+		{"single", []clustering.AggloStep{
+			step(0, 1, 2), step(1, 2, 4), step(2, 3, 10)}},
+		{"complete", []clustering.AggloStep{
+			step(0, 1, 2), step(1, 2, 6), step(2, 3, 30)}},
+	}
+	for _, test := range tests {
+		got := aggloSteps(d, 4, test.linkage)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("aggloSteps(%q)=%v, want %v", test.linkage, got,
+				test.want)
+		}
+	}
+}
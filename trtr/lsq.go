@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/fluhus/biostuff/formats/newick"
+	"github.com/fluhus/frackyfrac/common"
+)
+
+// Least-squares stops when an iteration reduces the sum of squared residuals
+// by less than this fraction.
+const lsqTolerance = 1e-8
+
+// A tree's structure, for computing path lengths between leaves.
+type lsqTree struct {
+	nodes    []*newick.Node // Nodes in pre-order.
+	parent   []int          // Index of each node's parent, -1 for the root.
+	children [][]int        // Indexes of each node's children.
+	leaf     []int          // Distance index of each leaf, -1 for inner nodes.
+	leaves   []int          // Distance indexes of the leaves, in pre-order.
+	lo, hi   []int          // Range of each node's leaves in leaves.
+}
+
+// Returns the structure of the given tree, where names are the leaf names by
+// their index in the distances.
+func newLSQTree(tree *newick.Node, names []string) (*lsqTree, error) {
+	nameIdx := map[string]int{}
+	for i, name := range names {
+		if _, ok := nameIdx[name]; ok {
+			return nil, fmt.Errorf("duplicate leaf name: %q", name)
+		}
+		nameIdx[name] = i
+	}
+	t := &lsqTree{}
+	idx := map[*newick.Node]int{}
+	for n := range tree.PreOrder() {
+		idx[n] = len(t.nodes)
+		t.nodes = append(t.nodes, n)
+	}
+	t.parent = make([]int, len(t.nodes))
+	t.children = make([][]int, len(t.nodes))
+	t.leaf = make([]int, len(t.nodes))
+	t.lo = make([]int, len(t.nodes))
+	t.hi = make([]int, len(t.nodes))
+	t.parent[0] = -1
+	for i, n := range t.nodes {
+		for _, c := range n.Children {
+			t.parent[idx[c]] = i
+			t.children[i] = append(t.children[i], idx[c])
+		}
+		t.leaf[i] = -1
+		t.lo[i] = len(t.leaves) // A subtree's leaves follow it in pre-order.
+		if len(n.Children) == 0 {
+			j, ok := nameIdx[n.Name]
+			if !ok {
+				return nil, fmt.Errorf("leaf %q has no distances", n.Name)
+			}
+			t.leaf[i] = j
+			t.leaves = append(t.leaves, j)
+		}
+	}
+	if len(t.leaves) != len(names) {
+		return nil, fmt.Errorf("tree has %d leaves, want %d",
+			len(t.leaves), len(names))
+	}
+	for i := len(t.nodes) - 1; i >= 0; i-- {
+		if ch := t.children[i]; len(ch) > 0 {
+			t.hi[i] = t.hi[ch[len(ch)-1]]
+		} else {
+			t.hi[i] = t.lo[i] + 1
+		}
+	}
+	return t, nil
+}
+
+// Returns the path lengths between the leaves in flat pyramid order, given the
+// length of the branch above each node.
+func (t *lsqTree) pathLengths(lengths []float64) []float64 {
+	n := len(t.leaves)
+	result := make([]float64, n*(n-1)/2)
+	dist := make([]float64, len(t.nodes))
+	stack := []int{}
+	visited := make([]bool, len(t.nodes))
+	for start := range t.nodes {
+		a := t.leaf[start]
+		if a == -1 {
+			continue
+		}
+		clear(visited)
+		dist[start] = 0
+		visited[start] = true
+		stack = append(stack[:0], start)
+		for len(stack) > 0 {
+			u := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if b := t.leaf[u]; b != -1 && b < a {
+				result[common.PairIndex(a, b)] = dist[u]
+			}
+			if p := t.parent[u]; p != -1 && !visited[p] {
+				visited[p] = true
+				dist[p] = dist[u] + lengths[u]
+				stack = append(stack, p)
+			}
+			for _, c := range t.children[u] {
+				if !visited[c] {
+					visited[c] = true
+					dist[c] = dist[u] + lengths[c]
+					stack = append(stack, c)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// Returns, for the branch above each node, the sum of the given pair values
+// over the pairs of leaves whose path crosses it. This is the transpose of
+// pathLengths.
+func (t *lsqTree) crossSums(r []float64) []float64 {
+	n := len(t.leaves)
+	rowSums := make([]float64, n)
+	for i := range n {
+		for j := range i {
+			rowSums[i] += r[common.PairIndex(i, j)]
+			rowSums[j] += r[common.PairIndex(i, j)]
+		}
+	}
+	// Sums over pairs within each subtree, counted at their lowest common
+	// ancestor.
+	within := make([]float64, len(t.nodes))
+	for u := len(t.nodes) - 1; u >= 0; u-- {
+		ch := t.children[u]
+		for ci, c1 := range ch {
+			within[u] += within[c1]
+			for _, c2 := range ch[:ci] {
+				for _, a := range t.leaves[t.lo[c1]:t.hi[c1]] {
+					for _, b := range t.leaves[t.lo[c2]:t.hi[c2]] {
+						within[u] += r[common.PairIndex(a, b)]
+					}
+				}
+			}
+		}
+	}
+	result := make([]float64, len(t.nodes))
+	for u := 1; u < len(t.nodes); u++ {
+		for _, a := range t.leaves[t.lo[u]:t.hi[u]] {
+			result[u] += rowSums[a]
+		}
+		result[u] -= 2 * within[u]
+	}
+	return result
+}
+
+// Fits the tree's branch lengths to the given distances in flat pyramid
+// order by least squares, keeping its topology. names are the leaf names by
+// their index in the distances. Negative lengths are set to 0.
+//
+// Runs up to maxIters iterations, each taking time proportional to the number
+// of distances, and stops early once the fit stops improving. Exact tree
+// distances need about as many iterations as there are branches, while noisy
+// ones usually converge sooner.
+func fitBranchLengths(tree *newick.Node, distances []float64,
+	names []string, maxIters int) error {
+	t, err := newLSQTree(tree, names)
+	if err != nil {
+		return err
+	}
+	if len(names) < 2 {
+		return nil
+	}
+
+	// Conjugate gradient for least squares (CGLS), starting from the current
+	// lengths.
+	x := make([]float64, len(t.nodes))
+	for u := 1; u < len(t.nodes); u++ {
+		x[u] = t.nodes[u].Distance
+	}
+	r := t.pathLengths(x)
+	for i := range r {
+		r[i] = distances[i] - r[i]
+	}
+	s := t.crossSums(r)
+	p := append([]float64(nil), s...)
+	gamma := dot(s, s)
+	rss := dot(r, r)
+	for range maxIters {
+		if gamma == 0 {
+			break
+		}
+		q := t.pathLengths(p)
+		alpha := gamma / dot(q, q)
+		for i := range x {
+			x[i] += alpha * p[i]
+		}
+		for i := range r {
+			r[i] -= alpha * q[i]
+		}
+		newRSS := dot(r, r)
+		if rss-newRSS <= lsqTolerance*rss {
+			break
+		}
+		rss = newRSS
+		s = t.crossSums(r)
+		newGamma := dot(s, s)
+		for i := range p {
+			p[i] = s[i] + newGamma/gamma*p[i]
+		}
+		gamma = newGamma
+	}
+	// Only the sum of a binary root's branches is determined, so shift length
+	// between them rather than have one negative.
+	if ch := t.children[0]; len(ch) == 2 {
+		a, b := ch[0], ch[1]
+		if x[a] < 0 {
+			x[a], x[b] = 0, x[a]+x[b]
+		} else if x[b] < 0 {
+			x[a], x[b] = x[a]+x[b], 0
+		}
+	}
+	for u := 1; u < len(t.nodes); u++ {
+		t.nodes[u].Distance = max(x[u], 0)
+	}
+	return nil
+}
+
+// Returns the dot product of a and b.
+func dot(a, b []float64) float64 {
+	result := 0.0
+	for i := range a {
+		result += a[i] * b[i]
+	}
+	return result
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"math"
+	"slices"
+
+	"github.com/fluhus/biostuff/formats/newick"
+	"github.com/fluhus/frackyfrac/common"
+)
+
+// Creates a neighbor-joining tree from the given distances in flat pyramid
+// order, with names as the leaf names. If fast is true, searches for the pair
+// to join with RapidNJ's bounded search instead of checking all pairs.
+//
+// Returns an unrooted tree, whose top node has 3 children. Negative branch
+// lengths are set to 0.
+func neighborJoining(distances []float64, names []string, fast bool,
+) *newick.Node {
+	n := len(names)
+	nodes := make([]*newick.Node, n)
+	for i, name := range names {
+		nodes[i] = &newick.Node{Name: name}
+	}
+	switch n {
+	case 0:
+		panic("cannot build a tree with 0 leaves")
+	case 1:
+		return nodes[0]
+	case 2:
+		nodes[0].Distance = distances[0] / 2
+		nodes[1].Distance = distances[0] / 2
+		return &newick.Node{Children: nodes}
+	}
+
+	d := slices.Clone(distances)
+	dist := func(i, j int) float64 { return d[common.PairIndex(i, j)] }
+	active := make([]int, n) // Slots of the current nodes.
+	sums := make([]float64, n)
+	for i := range active {
+		active[i] = i
+		for j := range i {
+			sums[i] += d[common.PairIndex(i, j)]
+			sums[j] += d[common.PairIndex(i, j)]
+		}
+	}
+	var search *rapidSearch
+	if fast {
+		search = newRapidSearch(d, n)
+	}
+
+	for m := n; m > 3; m-- {
+		var i, j int
+		if fast {
+			i, j = search.closest(active, sums)
+		} else {
+			i, j = closestPair(d, active, sums)
+		}
+		dij := dist(i, j)
+		li := dij/2 + (sums[i]-sums[j])/float64(2*(m-2))
+		li = max(min(li, dij), 0)
+		nodes[i].Distance, nodes[j].Distance = li, dij-li
+		nodes[i] = &newick.Node{Children: []*newick.Node{nodes[i], nodes[j]}}
+		nodes[j] = nil
+
+		// The new node takes i's slot.
+		active = slices.DeleteFunc(active, func(k int) bool { return k == j })
+		sums[i] = 0
+		for _, k := range active {
+			if k == i {
+				continue
+			}
+			dik, djk := dist(i, k), dist(j, k)
+			duk := (dik + djk - dij) / 2
+			sums[k] += duk - dik - djk
+			sums[i] += duk
+			d[common.PairIndex(i, k)] = duk
+		}
+		if fast {
+			search.replace(i, j, active)
+		}
+	}
+
+	a, b, c := active[0], active[1], active[2]
+	dab, dac, dbc := dist(a, b), dist(a, c), dist(b, c)
+	nodes[a].Distance = max((dab+dac-dbc)/2, 0)
+	nodes[b].Distance = max((dab+dbc-dac)/2, 0)
+	nodes[c].Distance = max((dac+dbc-dab)/2, 0)
+	return &newick.Node{Children: []*newick.Node{nodes[a], nodes[b], nodes[c]}}
+}
+
+// Returns the pair of active slots that minimizes the neighbor-joining
+// criterion, by checking all pairs.
+func closestPair(d []float64, active []int, sums []float64) (int, int) {
+	m := float64(len(active))
+	best, bi, bj := math.Inf(1), -1, -1
+	for ii, i := range active {
+		for _, j := range active[:ii] {
+			q := (m-2)*d[common.PairIndex(i, j)] - sums[i] - sums[j]
+			if q < best {
+				best, bi, bj = q, i, j
+			}
+		}
+	}
+	return bi, bj
+}
+
+// An entry in a row of RapidNJ's sorted distance matrix.
+type rapidEntry struct {
+	d   float64 // Distance.
+	j   int32   // Slot of the other node.
+	gen int32   // Generation of the other node's slot.
+}
+
+// RapidNJ's search state (Simonsen et al. 2008). Each row holds the
+// distances of a node, sorted in ascending order, which allows stopping the
+// search of a row once its remaining pairs cannot beat the best pair.
+type rapidSearch struct {
+	d    []float64      // Distances in flat pyramid order, by slot.
+	rows [][]rapidEntry // Sorted distances of each slot.
+	gen  []int32        // Generation of each slot, increases when it is reused.
+}
+
+// Returns a search state for n initial nodes with the given distances.
+func newRapidSearch(d []float64, n int) *rapidSearch {
+	s := &rapidSearch{d: d, rows: make([][]rapidEntry, n),
+		gen: make([]int32, n)}
+	active := make([]int, n)
+	for i := range active {
+		active[i] = i
+	}
+	for i := range n {
+		s.buildRow(i, active)
+	}
+	return s
+}
+
+// Builds the sorted row of slot i, with the given active slots.
+func (s *rapidSearch) buildRow(i int, active []int) {
+	row := make([]rapidEntry, 0, len(active)-1)
+	for _, j := range active {
+		if j != i {
+			d := s.d[common.PairIndex(i, j)]
+			row = append(row, rapidEntry{d, int32(j), s.gen[j]})
+		}
+	}
+	slices.SortFunc(row, func(a, b rapidEntry) int {
+		if a.d < b.d {
+			return -1
+		}
+		if a.d > b.d {
+			return 1
+		}
+		return int(a.j - b.j)
+	})
+	s.rows[i] = row
+}
+
+// Updates the state after the nodes in slots i and j were joined into a new
+// node in slot i.
+func (s *rapidSearch) replace(i, j int, active []int) {
+	s.gen[i]++
+	s.gen[j]++
+	s.rows[j] = nil
+	s.buildRow(i, active)
+}
+
+// Returns whether the entry points to a node that was joined.
+func (s *rapidSearch) stale(e rapidEntry) bool {
+	return s.gen[e.j] != e.gen || s.rows[e.j] == nil
+}
+
+// Returns the pair of active slots that minimizes the neighbor-joining
+// criterion.
+func (s *rapidSearch) closest(active []int, sums []float64) (int, int) {
+	m := float64(len(active))
+	maxSum := math.Inf(-1)
+	for _, i := range active {
+		maxSum = max(maxSum, sums[i])
+	}
+	best, bi, bj := math.Inf(1), -1, -1
+	for _, i := range active {
+		row := s.rows[i]
+		for len(row) > 0 && s.stale(row[0]) {
+			row = row[1:]
+		}
+		s.rows[i] = row
+		for _, e := range row {
+			if (m-2)*e.d-sums[i]-maxSum >= best {
+				break
+			}
+			if s.stale(e) {
+				continue
+			}
+			q := (m-2)*e.d - sums[i] - sums[e.j]
+			if q < best {
+				best, bi, bj = q, i, int(e.j)
+			}
+		}
+	}
+	return bi, bj
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/fluhus/biostuff/formats/newick"
+)
+
+func TestNeighborJoining(t *testing.T) {
+	// From Saitou and Nei 1987, via Wikipedia.
+	names := []string{"a", "b", "c", "d", "e"}
+	d := []float64{5, 9, 10, 9, 10, 8, 8, 9, 7, 3}
+	for _, fast := range []bool{false, true} {
+		tree := neighborJoining(d, names, fast)
+		got := leafDistances(tree, names)
+		for i := range d {
+			if math.Abs(got[i]-d[i]) > 1e-9 {
+				t.Fatalf("neighborJoining(fast=%v) distances=%v, want %v",
+					fast, got, d)
+			}
+		}
+		if len(tree.Children) != 3 {
+			t.Fatalf("neighborJoining(fast=%v) has %d top children, want 3",
+				fast, len(tree.Children))
+		}
+	}
+}
+
+func TestNeighborJoining_random(t *testing.T) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	for range 20 {
+		n := 3 + rnd.IntN(30)
+		names := make([]string, n)
+		nodes := make([]*newick.Node, n)
+		for i := range names {
+			names[i] = fmt.Sprint("s", i)
+			nodes[i] = &newick.Node{Name: names[i]}
+		}
+		for len(nodes) > 1 {
+			i := rnd.IntN(len(nodes))
+			a := nodes[i]
+			nodes = append(nodes[:i], nodes[i+1:]...)
+			j := rnd.IntN(len(nodes))
+			a.Distance = 0.1 + rnd.Float64()
+			nodes[j].Distance = 0.1 + rnd.Float64()
+			nodes[j] = &newick.Node{Children: []*newick.Node{a, nodes[j]}}
+		}
+		d := leafDistances(nodes[0], names)
+		for _, fast := range []bool{false, true} {
+			got := leafDistances(neighborJoining(d, names, fast), names)
+			for i := range d {
+				if math.Abs(got[i]-d[i]) > 1e-9 {
+					t.Fatalf("neighborJoining(fast=%v) distances=%v, want %v",
+						fast, got, d)
+				}
+			}
+		}
+	}
+}
+
+// Returns the distances between the leaves of the tree with the given names,
+// in flat pyramid order.
+func leafDistances(tree *newick.Node, names []string) []float64 {
+	type edge struct {
+		to   *newick.Node
+		dist float64
+	}
+	adj := map[*newick.Node][]edge{}
+	leaves := map[string]*newick.Node{}
+	for n := range tree.PreOrder() {
+		for _, c := range n.Children {
+			adj[n] = append(adj[n], edge{c, c.Distance})
+			adj[c] = append(adj[c], edge{n, c.Distance})
+		}
+		if len(n.Children) == 0 {
+			leaves[n.Name] = n
+		}
+	}
+	var result []float64
+	for i := range names {
+		dists := map[*newick.Node]float64{leaves[names[i]]: 0}
+		stack := []*newick.Node{leaves[names[i]]}
+		for len(stack) > 0 {
+			u := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, e := range adj[u] {
+				if _, ok := dists[e.to]; !ok {
+					dists[e.to] = dists[u] + e.dist
+					stack = append(stack, e.to)
+				}
+			}
+		}
+		for j := range i {
+			result = append(result, dists[leaves[names[j]]])
+		}
+	}
+	return result
+}
+
+func TestFitBranchLengths(t *testing.T) {
+	rnd := rand.New(rand.NewPCG(3, 4))
+	for range 20 {
+		n := 2 + rnd.IntN(30)
+		names := make([]string, n)
+		nodes := make([]*newick.Node, n)
+		for i := range names {
+			names[i] = fmt.Sprint("s", i)
+			nodes[i] = &newick.Node{Name: names[i]}
+		}
+		for len(nodes) > 1 {
+			i := rnd.IntN(len(nodes))
+			a := nodes[i]
+			nodes = append(nodes[:i], nodes[i+1:]...)
+			j := rnd.IntN(len(nodes))
+			a.Distance = 0.1 + rnd.Float64()
+			nodes[j].Distance = 0.1 + rnd.Float64()
+			nodes[j] = &newick.Node{Children: []*newick.Node{a, nodes[j]}}
+		}
+		tree := nodes[0]
+		d := leafDistances(tree, names)
+		for n := range tree.PreOrder() {
+			n.Distance = rnd.Float64()
+		}
+		if err := fitBranchLengths(tree, d, names, 1000); err != nil {
+			t.Fatalf("fitBranchLengths() failed: %v", err)
+		}
+		got := leafDistances(tree, names)
+		for i := range d {
+			if math.Abs(got[i]-d[i]) > 1e-6 {
+				t.Fatalf("fitBranchLengths() distances=%v, want %v", got, d)
+			}
+		}
+	}
+}
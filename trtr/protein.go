@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"iter"
+)
+
+// Reduced amino acid alphabets, mapping each amino acid to the letter of its
+// class. The letters are the ones sourmash uses.
+var alphabets = map[string]map[byte]byte{
+	"protein": reducedAlphabet(map[byte]string{
+		'A': "A", 'C': "C", 'D': "D", 'E': "E", 'F': "F", 'G': "G", 'H': "H",
+		'I': "I", 'K': "K", 'L': "L", 'M': "M", 'N': "N", 'P': "P", 'Q': "Q",
+		'R': "R", 'S': "S", 'T': "T", 'V': "V", 'W': "W", 'Y': "Y",
+	}),
+	"dayhoff": reducedAlphabet(map[byte]string{
+		'a': "C", 'b': "AGPST", 'c': "DENQ", 'd': "HKR", 'e': "ILMV",
+		'f': "FWY",
+	}),
+	"hp": reducedAlphabet(map[byte]string{
+		'h': "AFGILMPVWY", 'p': "CDEHKNQRST",
+	}),
+}
+
+// Returns an alphabet from a map from class letter to its amino acids.
+func reducedAlphabet(classes map[byte]string) map[byte]byte {
+	result := map[byte]byte{}
+	for class, aas := range classes {
+		for _, aa := range []byte(aas) {
+			result[aa] = class
+		}
+	}
+	return result
+}
+
+// Iterates over the amino acid k-mers of a protein sequence, in the alphabet
+// of the given molecule type. K-mers are not canonicalized, and ones with
+// characters other than the 20 amino acids are skipped.
+func proteinKmers(seq []byte, k int, molecule string) iter.Seq[[]byte] {
+	alphabet := alphabets[molecule]
+	return func(yield func([]byte) bool) {
+		seq := bytes.ToUpper(seq)
+		start := 0 // Start of the current run of valid characters.
+		for i, c := range seq {
+			class, ok := alphabet[c]
+			if !ok {
+				start = i + 1
+				continue
+			}
+			seq[i] = class
+			if i+1-start >= k {
+				if !yield(seq[i+1-k : i+1]) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProteinKmers(t *testing.T) {
+	tests := []struct {
+		molecule string
+		want     []string
+	}{
+		{"protein", []string{"MKV", "KVL", "CWA"}},
+		{"dayhoff", []string{"ede", "dee", "afb"}},
+		{"hp", []string{"hph", "phh", "phh"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for kmer := range proteinKmers([]byte("Mkvl*CWA"), 3, test.molecule) {
+			got = append(got, string(kmer))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("proteinKmers(%q)=%v, want %v",
+				test.molecule, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"iter"
+	"path/filepath"
+	"strings"
+
+	"github.com/fluhus/biostuff/formats/fasta"
+	"github.com/fluhus/biostuff/formats/fastq"
+)
+
+const (
+	// Number of rows in a k-mer counting sketch.
+	countRows = 4
+
+	// Log2 of the number of counters in each row of a k-mer counting sketch.
+	countBits = 22
+)
+
+// Multipliers for deriving a counter index in each row from a hash.
+var countMultipliers = [countRows]uint64{
+	0x9e3779b97f4a7c15, 0xbf58476d1ce4e5b9, 0x94d049bb133111eb,
+	0xd6e8feb86659fd93,
+}
+
+// Returns whether the given file is a fastq file, by its extension.
+func isFastq(file string) bool {
+	file = strings.TrimSuffix(file, ".gz")
+	switch filepath.Ext(file) {
+	case ".fq", ".fastq":
+		return true
+	}
+	return false
+}
+
+// Iterates over the records in a fasta or fastq file. Fastq records are
+// given without their qualities.
+func iterRecords(file string) iter.Seq2[*fasta.Fasta, error] {
+	if !isFastq(file) {
+		return fasta.File(file)
+	}
+	return func(yield func(*fasta.Fasta, error) bool) {
+		for fq, err := range fastq.File(file) {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(&fasta.Fasta{Name: fq.Name, Sequence: fq.Sequence},
+				nil) {
+				return
+			}
+		}
+	}
+}
+
+// A count-min sketch of k-mer hashes, for filtering out k-mers that come from
+// sequencing errors. Counts may be overestimated but not underestimated.
+type kmerCounter struct {
+	counts [countRows][]uint16
+}
+
+// Returns an empty counter.
+func newKmerCounter() *kmerCounter {
+	c := &kmerCounter{}
+	for i := range c.counts {
+		c.counts[i] = make([]uint16, 1<<countBits)
+	}
+	return c
+}
+
+// Returns a counter for the -min-count filter of the given file, or nil if it
+// is off. Only fastq files are filtered, since assemblies have no errors to
+// filter out and most of their k-mers appear once.
+func newMinCounter(file string) *kmerCounter {
+	if *minCount <= 1 || !isFastq(file) {
+		return nil
+	}
+	return newKmerCounter()
+}
+
+// Counts the given hash and returns its estimated count so far. Uses
+// conservative update, which increments only the minimal counters.
+func (c *kmerCounter) add(x uint64) int {
+	var idx [countRows]uint64
+	m := uint16(1<<16 - 1)
+	for i := range c.counts {
+		idx[i] = (x * countMultipliers[i]) >> (64 - countBits)
+		m = min(m, c.counts[i][idx[i]])
+	}
+	if m == 1<<16-1 {
+		return int(m)
+	}
+	m++
+	for i := range c.counts {
+		c.counts[i][idx[i]] = max(c.counts[i][idx[i]], m)
+	}
+	return int(m)
+}
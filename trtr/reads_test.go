@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIterRecords(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"x.fa": ">a\nACG\nTT\n>b\nGGA\n",
+		"x.fq": "@a\nACGTT\n+\nIIIII\n@b\nGGA\n+\nIII\n",
+	}
+	want := []string{"a:ACGTT", "b:GGA"}
+	for name, data := range files {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for fa, err := range iterRecords(file) {
+			if err != nil {
+				t.Fatalf("iterRecords(%q) failed: %v", name, err)
+			}
+			got = append(got, string(fa.Name)+":"+string(fa.Sequence))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("iterRecords(%q)=%v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestKmerCounter(t *testing.T) {
+	c := newKmerCounter()
+	for i := range uint64(1000) {
+		for j := range i%5 + 1 {
+			if got := c.add(i); got < int(j)+1 {
+				t.Fatalf("add(%d)=%d, want at least %d", i, got, j+1)
+			}
+		}
+	}
+	exact := 0
+	for i := range uint64(1000) {
+		if c.add(i) == int(i%5)+2 {
+			exact++
+		}
+	}
+	if exact < 990 {
+		t.Errorf("got %d exact counts out of 1000, want at least 990", exact)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+
+	"github.com/fluhus/gostuff/minhash"
+)
+
+// Returns the greatest hash that a FracMinHash sketch with the given scale
+// keeps, computed like in sourmash.
+func scaledMaxHash(scale uint) uint64 {
+	if scale <= 1 {
+		return math.MaxUint64
+	}
+	return uint64(math.Round(float64(math.MaxUint64) / float64(scale)))
+}
+
+// Returns the number of hashes that the given FracMinHash sketches share.
+func scaledIntersection(a, b *minhash.MinHash[uint64]) int {
+	// Views are sorted in descending order.
+	va, vb := a.View(), b.View()
+	result := 0
+	for i, j := 0, 0; i < len(va) && j < len(vb); {
+		switch {
+		case va[i] > vb[j]:
+			i++
+		case va[i] < vb[j]:
+			j++
+		default:
+			result++
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// Returns the Mash distance between the given FracMinHash sketches, by their
+// Jaccard similarity.
+func scaledMashDistance(a, b *minhash.MinHash[uint64]) float64 {
+	inter := scaledIntersection(a, b)
+	union := len(a.View()) + len(b.View()) - inter
+	if union == 0 {
+		return 1
+	}
+	return jaccardToMash(float64(inter) / float64(union))
+}
+
+// Returns 1 minus the ANI of the given FracMinHash sketches, estimated by the
+// containment of the smaller one in the other, as in sourmash.
+func scaledANIDistance(a, b *minhash.MinHash[uint64]) float64 {
+	inter := scaledIntersection(a, b)
+	smaller := min(len(a.View()), len(b.View()))
+	if smaller == 0 {
+		return 1
+	}
+	containment := float64(inter) / float64(smaller)
+	return 1 - math.Pow(containment, 1/float64(*k))
+}
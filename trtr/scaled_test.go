@@ -0,0 +1,35 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/fluhus/gostuff/minhash"
+)
+
+func TestScaledDistances(t *testing.T) {
+	defer func(k0 uint) { *k = k0 }(*k)
+	*k = 2
+	sketch := func(x ...uint64) *minhash.MinHash[uint64] {
+		mh := minhash.New[uint64](max(len(x), 1))
+		for _, xx := range x {
+			mh.Push(xx)
+		}
+		mh.Sort()
+		return mh
+	}
+	a := sketch(1, 3, 5, 7, 9, 11, 13, 15)
+	b := sketch(3, 7, 20, 30)
+	if got := scaledIntersection(a, b); got != 2 {
+		t.Errorf("scaledIntersection(a,b)=%v, want 2", got)
+	}
+	if got, want := scaledANIDistance(a, b), 1-math.Sqrt(0.5); got != want {
+		t.Errorf("scaledANIDistance(a,b)=%v, want %v", got, want)
+	}
+	if got, want := scaledMashDistance(a, b), jaccardToMash(0.2); got != want {
+		t.Errorf("scaledMashDistance(a,b)=%v, want %v", got, want)
+	}
+	if got := scaledANIDistance(a, sketch()); got != 1 {
+		t.Errorf("scaledANIDistance(a,{})=%v, want 1", got)
+	}
+}
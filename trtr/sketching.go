@@ -1,28 +1,110 @@
 package main
 
 import (
+	"fmt"
+	"hash"
+	"maps"
+	"path/filepath"
+	"slices"
+
 	"github.com/fluhus/gostuff/jio"
 	"github.com/fluhus/gostuff/minhash"
 	"github.com/spaolacci/murmur3"
 )
 
-// Creates a kmer sketch for the given fasta file.
-func sketchFile(fin, fout string) error {
-	mh := minhash.New[uint64](int(*n))
-	h := murmur3.New64()
+// Returns the sketches of the given input file. A fasta file gives one sketch
+// named by the file's base name, or one for each leaf by -split. A sketch file
+// may give several sketches.
+func fileSketches(file string) ([]namedSketch, error) {
+	if isSketchFile(file) {
+		return readSketchFile(file)
+	}
+	if *split != "file" {
+		result, _, err := splitSketches(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		return result, nil
+	}
+	mh, err := sketchFasta(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return []namedSketch{{filepath.Base(file), mh}}, nil
+}
+
+// Creates a kmer sketch for the given fasta or fastq file, a FracMinHash
+// sketch if -scaled is set.
+func sketchFasta(fin string) (*minhash.MinHash[uint64], error) {
+	s := newSketcher(fin)
 	for kmer, err := range iterKmers(fin, int(*k)) {
 		if err != nil {
-			return err
+			return nil, err
 		}
-		h.Reset()
-		h.Write(kmer)
-		mh.Push(h.Sum64())
+		s.add(kmer)
 	}
-	mh.Sort()
-	if err := jio.Write(fout, mh); err != nil {
-		return err
+	return s.sketch(), nil
+}
+
+// Collects k-mers into a bottom-n sketch, or a FracMinHash sketch if -scaled
+// is set.
+type sketcher struct {
+	h      hash.Hash64
+	mh     *minhash.MinHash[uint64] // Bottom-n sketch.
+	hashes map[uint64]struct{}      // FracMinHash hashes.
+	limit  uint64                   // FracMinHash threshold.
+	counts *kmerCounter             // For -min-count, may be nil.
+}
+
+// Returns an empty sketcher for the k-mers of the given file.
+func newSketcher(file string) *sketcher {
+	s := &sketcher{
+		h:      murmur3.New64WithSeed(uint32(*seed)),
+		counts: newMinCounter(file),
+	}
+	if *scaled != 0 {
+		s.hashes = map[uint64]struct{}{}
+		s.limit = scaledMaxHash(*scaled)
+	} else {
+		s.mh = minhash.New[uint64](int(*n))
+	}
+	return s
+}
+
+// Adds a k-mer to the sketch.
+func (s *sketcher) add(kmer []byte) {
+	s.h.Reset()
+	s.h.Write(kmer)
+	x := s.h.Sum64()
+
+	// Only hashes that can enter the sketch are counted.
+	if s.mh != nil {
+		if v := s.mh.View(); len(v) == s.mh.K() && x >= v[0] {
+			return
+		}
+	} else if x > s.limit {
+		return
+	}
+	if s.counts != nil && s.counts.add(x) < int(*minCount) {
+		return
+	}
+
+	if s.mh != nil {
+		s.mh.Push(x)
+	} else {
+		s.hashes[x] = struct{}{}
+	}
+}
+
+// Returns the sketch of the added k-mers. A FracMinHash sketch holds all the
+// kept hashes, so its k is the number of hashes.
+func (s *sketcher) sketch() *minhash.MinHash[uint64] {
+	if s.mh == nil {
+		return newSketch(slices.Collect(maps.Keys(s.hashes)),
+			max(len(s.hashes), 1))
 	}
-	return nil
+	s.mh.Sort()
+	return s.mh
 }
 
 // Loads the sketches saved in the given file list.
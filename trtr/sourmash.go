@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fluhus/gostuff/aio"
+	"github.com/fluhus/gostuff/minhash"
+)
+
+// A sketch with the name of its leaf.
+type namedSketch struct {
+	name string
+	mh   *minhash.MinHash[uint64]
+}
+
+// A sourmash signature file entry.
+type sourmashSignature struct {
+	Class        string            `json:"class"`
+	Email        string            `json:"email"`
+	HashFunction string            `json:"hash_function"`
+	Filename     string            `json:"filename"`
+	Name         string            `json:"name,omitempty"`
+	License      string            `json:"license"`
+	Signatures   []sourmashMinHash `json:"signatures"`
+	Version      float64           `json:"version"`
+}
+
+// A sketch in a sourmash signature.
+type sourmashMinHash struct {
+	Num      int      `json:"num"`
+	KSize    int      `json:"ksize"`
+	Seed     uint64   `json:"seed"`
+	MaxHash  uint64   `json:"max_hash"`
+	Mins     []uint64 `json:"mins"`
+	MD5Sum   string   `json:"md5sum"`
+	Molecule string   `json:"molecule"`
+}
+
+// The JSON dump of a Mash sketch file, from 'mash info -d'.
+type mashDump struct {
+	Kmer         int          `json:"kmer"`
+	Alphabet     string       `json:"alphabet"`
+	PreserveCase bool         `json:"preserveCase"`
+	Canonical    bool         `json:"canonical"`
+	SketchSize   int          `json:"sketchSize"`
+	HashType     string       `json:"hashType,omitempty"`
+	HashBits     int          `json:"hashBits"`
+	HashSeed     uint64       `json:"hashSeed"`
+	Sketches     []mashSketch `json:"sketches"`
+}
+
+// A sketch in a Mash JSON dump.
+type mashSketch struct {
+	Name   string   `json:"name"`
+	Hashes []uint64 `json:"hashes"`
+}
+
+// Mash's alphabets, by molecule.
+const (
+	mashDNA     = "ACGT"
+	mashProtein = "ACDEFGHIKLMNPQRSTVWY"
+)
+
+// Returns whether the given file is a sketch file rather than a fasta file,
+// by its extension.
+func isSketchFile(file string) bool {
+	file = strings.TrimSuffix(file, ".gz")
+	switch filepath.Ext(file) {
+	case ".sig", ".json", ".msh":
+		return true
+	}
+	return false
+}
+
+// Reads the sketches in a sourmash signature file or a Mash JSON dump, and
+// adapts them to the sketching parameters in the arguments. Sketches with
+// incompatible parameters are rejected.
+func readSketchFile(file string) ([]namedSketch, error) {
+	if strings.HasSuffix(file, ".msh") {
+		return nil, fmt.Errorf("%s: binary Mash sketches are not supported, "+
+			"convert them with 'mash info -d' and use the JSON output", file)
+	}
+	f, err := aio.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	var result []namedSketch
+	switch {
+	case bytes.HasPrefix(data, []byte("[")):
+		var sigs []sourmashSignature
+		if err := json.Unmarshal(data, &sigs); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		result, err = fromSourmash(sigs, file)
+	case bytes.HasPrefix(data, []byte("{")):
+		var m struct {
+			Sketches json.RawMessage `json:"sketches"`
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		if m.Sketches != nil {
+			var dump mashDump
+			if err := json.Unmarshal(data, &dump); err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			result, err = fromMash(&dump)
+		} else {
+			var sig sourmashSignature
+			if err := json.Unmarshal(data, &sig); err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			result, err = fromSourmash([]sourmashSignature{sig}, file)
+		}
+	default:
+		return nil, fmt.Errorf("%s: not a JSON sketch file", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%s: no %s sketches found for k=%d", file,
+			*molecule, *k)
+	}
+	return result, nil
+}
+
+// Converts sourmash signatures to sketches. Only sketches with the k in the
+// arguments are used, so that signatures with several k's can be read.
+func fromSourmash(sigs []sourmashSignature, file string,
+) ([]namedSketch, error) {
+	var result []namedSketch
+	for i, sig := range sigs {
+		if sig.HashFunction != "" && sig.HashFunction != "0.murmur64" {
+			return nil, fmt.Errorf("unsupported hash function: %q",
+				sig.HashFunction)
+		}
+		name := sig.Name
+		if name == "" {
+			name = filepath.Base(sig.Filename)
+		}
+		if name == "" || name == "." {
+			name = fmt.Sprintf("%s_%d", filepath.Base(file), i+1)
+		}
+		for _, s := range sig.Signatures {
+			if s.KSize != sourmashK() ||
+				!strings.EqualFold(s.Molecule, *molecule) {
+				continue
+			}
+			if s.Seed != uint64(*seed) {
+				return nil, fmt.Errorf("%q has seed %d, want %d",
+					name, s.Seed, *seed)
+			}
+			var scale uint
+			if s.MaxHash != 0 {
+				scale = uint(math.Round(
+					float64(math.MaxUint64) / float64(s.MaxHash)))
+			}
+			mh, err := adaptSketch(s.Mins, s.Num, scale)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %v", name, err)
+			}
+			result = append(result, namedSketch{name, mh})
+		}
+	}
+	return result, nil
+}
+
+// Converts a Mash JSON dump to sketches.
+func fromMash(dump *mashDump) ([]namedSketch, error) {
+	if dump.Kmer != int(*k) {
+		return nil, fmt.Errorf("mash sketches have k=%d, want %d",
+			dump.Kmer, *k)
+	}
+	if dump.HashSeed != uint64(*seed) {
+		return nil, fmt.Errorf("mash sketches have seed %d, want %d",
+			dump.HashSeed, *seed)
+	}
+	if dump.HashBits != 64 {
+		return nil, fmt.Errorf("mash sketches have %d-bit hashes, want 64 "+
+			"(mash uses 32 bits for k<=16)", dump.HashBits)
+	}
+	switch *molecule {
+	case "dna":
+		if !dump.Canonical || dump.PreserveCase || dump.Alphabet != mashDNA {
+			return nil, fmt.Errorf("mash sketches should be canonical " +
+				"nucleotide sketches")
+		}
+	case "protein":
+		if dump.Canonical || dump.PreserveCase ||
+			dump.Alphabet != mashProtein {
+			return nil, fmt.Errorf("mash sketches should be amino acid " +
+				"sketches")
+		}
+	default:
+		return nil, fmt.Errorf("mash has no %s sketches", *molecule)
+	}
+	var result []namedSketch
+	for _, s := range dump.Sketches {
+		mh, err := adaptSketch(s.Hashes, dump.SketchSize, 0)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", s.Name, err)
+		}
+		result = append(result, namedSketch{s.Name, mh})
+	}
+	return result, nil
+}
+
+// Returns a sketch of the given hashes that matches the sketching parameters
+// in the arguments. num is the size of a bottom-num sketch, and scale is the
+// scale of a FracMinHash sketch. Returns an error if the hashes cannot give
+// such a sketch.
+func adaptSketch(hashes []uint64, num int, scale uint) (
+	*minhash.MinHash[uint64], error) {
+	hashes = slices.Clone(hashes)
+	slices.Sort(hashes)
+	hashes = slices.Compact(hashes)
+	if *scaled != 0 {
+		if scale == 0 || scale > *scaled {
+			return nil, fmt.Errorf("need a scaled sketch with scale at most "+
+				"%d", *scaled)
+		}
+		threshold := scaledMaxHash(*scaled)
+		for len(hashes) > 0 && hashes[len(hashes)-1] > threshold {
+			hashes = hashes[:len(hashes)-1]
+		}
+		return newSketch(hashes, max(len(hashes), 1)), nil
+	}
+	// A sketch with fewer hashes than its size has all of its genome's hashes.
+	complete := scale == 0 && len(hashes) < num
+	if len(hashes) < int(*n) && !complete {
+		return nil, fmt.Errorf("has %d hashes, need %d", len(hashes), *n)
+	}
+	return newSketch(hashes[:min(len(hashes), int(*n))], int(*n)), nil
+}
+
+// Returns a sketch of size n with the given hashes.
+func newSketch(hashes []uint64, n int) *minhash.MinHash[uint64] {
+	mh := minhash.New[uint64](n)
+	for _, h := range hashes {
+		mh.Push(h)
+	}
+	mh.Sort()
+	return mh
+}
+
+// Writes the given sketches as a sourmash signature file, with the given
+// names.
+func writeSourmash(file string, names []string,
+	sketches []*minhash.MinHash[uint64]) error {
+	sigs := make([]sourmashSignature, len(sketches))
+	for i, s := range sketches {
+		mins := slices.Clone(s.View())
+		slices.Sort(mins)
+		mh := sourmashMinHash{
+			KSize:    sourmashK(),
+			Seed:     uint64(*seed),
+			Mins:     mins,
+			MD5Sum:   sourmashMD5(sourmashK(), mins),
+			Molecule: *molecule,
+		}
+		if *molecule == "dna" {
+			mh.Molecule = "DNA"
+		}
+		if *scaled != 0 {
+			mh.MaxHash = scaledMaxHash(*scaled)
+		} else {
+			mh.Num = int(*n)
+		}
+		sigs[i] = sourmashSignature{
+			Class:        "sourmash_signature",
+			HashFunction: "0.murmur64",
+			Filename:     names[i],
+			Name:         names[i],
+			License:      "CC0",
+			Signatures:   []sourmashMinHash{mh},
+			Version:      0.4,
+		}
+	}
+	return writeJSON(file, sigs)
+}
+
+// Writes the given sketches as a Mash JSON dump, like the output of
+// 'mash info -d', with the given names.
+func writeMash(file string, names []string,
+	sketches []*minhash.MinHash[uint64]) error {
+	if *scaled != 0 {
+		return fmt.Errorf("mash has no scaled sketches")
+	}
+	if *k <= 16 {
+		return fmt.Errorf("mash uses 32-bit hashes for k<=16, want k>16")
+	}
+	dump := mashDump{
+		Kmer:       int(*k),
+		SketchSize: int(*n),
+		HashType:   "MurmurHash3_x64_128",
+		HashBits:   64,
+		HashSeed:   uint64(*seed),
+	}
+	switch *molecule {
+	case "dna":
+		dump.Alphabet, dump.Canonical = mashDNA, true
+	case "protein":
+		dump.Alphabet = mashProtein
+	default:
+		return fmt.Errorf("mash has no %s sketches", *molecule)
+	}
+	for i, s := range sketches {
+		hashes := slices.Clone(s.View())
+		slices.Sort(hashes)
+		dump.Sketches = append(dump.Sketches, mashSketch{names[i], hashes})
+	}
+	return writeJSON(file, dump)
+}
+
+// Writes x to the given file as JSON.
+func writeJSON(file string, x any) error {
+	f, err := aio.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(x); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Returns k as sourmash records it, which is in nucleotides also for amino
+// acid sketches.
+func sourmashK() int {
+	if *molecule == "dna" {
+		return int(*k)
+	}
+	return int(*k) * 3
+}
+
+// Returns the MD5 checksum of a sketch, the way sourmash computes it.
+func sourmashMD5(k int, mins []uint64) string {
+	h := md5.New()
+	h.Write([]byte(strconv.Itoa(k)))
+	for _, x := range mins {
+		h.Write([]byte(strconv.FormatUint(x, 10)))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
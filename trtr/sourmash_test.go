@@ -0,0 +1,157 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/fluhus/gostuff/minhash"
+)
+
+func TestSourmashRoundTrip(t *testing.T) {
+	defer func(k0, n0, s0, seed0 uint) {
+		*k, *n, *scaled, *seed = k0, n0, s0, seed0
+	}(*k, *n, *scaled, *seed)
+	*k, *n, *scaled, *seed = 5, 3, 0, 42
+
+	file := filepath.Join(t.TempDir(), "x.sig")
+	a := newSketch([]uint64{10, 20, 30}, 3)
+	b := newSketch([]uint64{5, 25}, 3)
+	if err := writeSourmash(file, []string{"a", "b"},
+		[]*minhash.MinHash[uint64]{a, b}); err != nil {
+		t.Fatalf("writeSourmash() failed: %v", err)
+	}
+	got, err := readSketchFile(file)
+	if err != nil {
+		t.Fatalf("readSketchFile() failed: %v", err)
+	}
+	if len(got) != 2 || got[0].name != "a" || got[1].name != "b" {
+		t.Fatalf("readSketchFile()=%v, want sketches a and b", got)
+	}
+	for i, want := range [][]uint64{{10, 20, 30}, {5, 25}} {
+		view := slices.Sorted(slices.Values(got[i].mh.View()))
+		if !reflect.DeepEqual(view, want) {
+			t.Errorf("readSketchFile()[%d]=%v, want %v", i, view, want)
+		}
+	}
+
+	*n = 2
+	if _, err := readSketchFile(file); err != nil {
+		t.Errorf("readSketchFile(n=2) failed: %v", err)
+	}
+	*n = 4
+	if _, err := readSketchFile(file); err == nil {
+		t.Errorf("readSketchFile(n=4) succeeded, want error")
+	}
+	*n, *seed = 3, 0
+	if _, err := readSketchFile(file); err == nil {
+		t.Errorf("readSketchFile(seed=0) succeeded, want error")
+	}
+	*seed, *k = 42, 6
+	if _, err := readSketchFile(file); err == nil {
+		t.Errorf("readSketchFile(k=6) succeeded, want error")
+	}
+}
+
+func TestReadSketchFile_mash(t *testing.T) {
+	defer func(k0, n0, s0, seed0 uint) {
+		*k, *n, *scaled, *seed = k0, n0, s0, seed0
+	}(*k, *n, *scaled, *seed)
+	*k, *n, *scaled, *seed = 21, 2, 0, 42
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "x.json")
+	dump := `{"kmer": 21, "alphabet": "ACGT", "preserveCase": false,
+		"canonical": true, "sketchSize": 3, "hashType": "MurmurHash3_x64_128",
+		"hashBits": 64, "hashSeed": 42, "sketches": [
+		{"name": "g1.fa", "length": 100, "comment": "", "hashes": [3, 1, 2]}]}`
+	if err := os.WriteFile(file, []byte(dump), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readSketchFile(file)
+	if err != nil {
+		t.Fatalf("readSketchFile() failed: %v", err)
+	}
+	if len(got) != 1 || got[0].name != "g1.fa" {
+		t.Fatalf("readSketchFile()=%v, want g1.fa", got)
+	}
+	view := slices.Sorted(slices.Values(got[0].mh.View()))
+	if want := []uint64{1, 2}; !reflect.DeepEqual(view, want) {
+		t.Errorf("readSketchFile()=%v, want %v", view, want)
+	}
+
+	msh := filepath.Join(dir, "x.msh")
+	if _, err := readSketchFile(msh); err == nil {
+		t.Errorf("readSketchFile(%q) succeeded, want error", msh)
+	}
+}
+
+func TestIterKmers(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "x.fa")
+	if err := os.WriteFile(file, []byte(">x\nacgtNTTTA\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old bool) { *compat = old }(*compat)
+	tests := []struct {
+		compat bool
+		want   []string
+	}{
+		{false, []string{"acg", "acg", "Nac", "ANa", "AAN", "AAA", "TAA"}},
+		{true, []string{"ACG", "ACG", "AAA", "TAA"}},
+	}
+	for _, test := range tests {
+		*compat = test.compat
+		var got []string
+		for kmer, err := range iterKmers(file, 3) {
+			if err != nil {
+				t.Fatalf("iterKmers() failed: %v", err)
+			}
+			got = append(got, string(kmer))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("iterKmers(compat=%v)=%q, want %q",
+				test.compat, got, test.want)
+		}
+	}
+}
+
+func TestMashRoundTrip(t *testing.T) {
+	defer func(k0, n0, s0, seed0 uint) {
+		*k, *n, *scaled, *seed = k0, n0, s0, seed0
+	}(*k, *n, *scaled, *seed)
+	*k, *n, *scaled, *seed = 21, 3, 0, 42
+
+	file := filepath.Join(t.TempDir(), "x.json")
+	a := newSketch([]uint64{10, 20, 30}, 3)
+	b := newSketch([]uint64{5, 25}, 3)
+	if err := writeMash(file, []string{"a", "b"},
+		[]*minhash.MinHash[uint64]{a, b}); err != nil {
+		t.Fatalf("writeMash() failed: %v", err)
+	}
+	got, err := readSketchFile(file)
+	if err != nil {
+		t.Fatalf("readSketchFile() failed: %v", err)
+	}
+	if len(got) != 2 || got[0].name != "a" || got[1].name != "b" {
+		t.Fatalf("readSketchFile()=%v, want sketches a and b", got)
+	}
+	for i, want := range [][]uint64{{10, 20, 30}, {5, 25}} {
+		view := slices.Sorted(slices.Values(got[i].mh.View()))
+		if !reflect.DeepEqual(view, want) {
+			t.Errorf("readSketchFile()[%d]=%v, want %v", i, view, want)
+		}
+	}
+
+	*k = 16
+	if err := writeMash(file, []string{"a"},
+		[]*minhash.MinHash[uint64]{a}); err == nil {
+		t.Errorf("writeMash(k=16) succeeded, want error")
+	}
+	*k, *scaled = 21, 10
+	if err := writeMash(file, []string{"a"},
+		[]*minhash.MinHash[uint64]{a}); err == nil {
+		t.Errorf("writeMash(scaled=10) succeeded, want error")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/fluhus/gostuff/aio"
+)
+
+// Record names and their leaf names, for -split map.
+var splitMap map[string]string
+
+// Returns the leaf name of a record with the given name, by -split. The
+// record's name is the first word of its header.
+func recordLeaf(header []byte) (string, error) {
+	fields := bytes.Fields(header)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("record has no name")
+	}
+	name := string(fields[0])
+	switch *split {
+	case "prefix":
+		prefix, _, _ := strings.Cut(name, *splitSep)
+		return prefix, nil
+	case "map":
+		leaf, ok := splitMap[name]
+		if !ok {
+			return "", fmt.Errorf("record %q is not in %s", name, *splitMapFile)
+		}
+		return leaf, nil
+	default:
+		return name, nil
+	}
+}
+
+// Creates a sketch for each leaf of the given fasta or fastq file, grouping
+// its records by -split. Returns the sketches in the order of their leaves'
+// first records, and the record names of each leaf.
+func splitSketches(file string) ([]namedSketch, [][]string, error) {
+	index := map[string]int{} // Leaf name to its position.
+	var leaves []string
+	var sketchers []*sketcher
+	var records [][]string
+	i := 0
+	for fa, err := range iterRecords(file) {
+		if err != nil {
+			return nil, nil, err
+		}
+		i++
+		leaf, err := recordLeaf(fa.Name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("record #%d: %v", i, err)
+		}
+		j, ok := index[leaf]
+		if !ok {
+			j = len(leaves)
+			index[leaf] = j
+			leaves = append(leaves, leaf)
+			sketchers = append(sketchers, newSketcher(file))
+			records = append(records, nil)
+		}
+		records[j] = append(records[j], string(bytes.Fields(fa.Name)[0]))
+		for kmer := range seqKmers(fa.Sequence, int(*k)) {
+			sketchers[j].add(kmer)
+		}
+	}
+	result := make([]namedSketch, len(leaves))
+	for j, leaf := range leaves {
+		result[j] = namedSketch{leaf, sketchers[j].sketch()}
+	}
+	return result, records, nil
+}
+
+// Reads a -split map file, with a record name and its leaf name in each
+// line, separated by a tab.
+func readSplitMap(file string) (map[string]string, error) {
+	f, err := aio.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	result := map[string]string{}
+	sc := bufio.NewScanner(f)
+	i := 0
+	for sc.Scan() {
+		i++
+		line := strings.TrimRight(sc.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		record, leaf, ok := strings.Cut(line, "\t")
+		record, leaf = strings.TrimSpace(record), strings.TrimSpace(leaf)
+		if !ok || record == "" || leaf == "" {
+			return nil, fmt.Errorf("%s: line #%d: expected a record name and "+
+				"a leaf name separated by a tab", file, i)
+		}
+		if prev, ok := result[record]; ok && prev != leaf {
+			return nil, fmt.Errorf("%s: line #%d: record %q is mapped to "+
+				"both %q and %q", file, i, record, prev, leaf)
+		}
+		result[record] = leaf
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return result, nil
+}
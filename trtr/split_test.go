@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitSketches(t *testing.T) {
+	defer func(k0, n0 uint, split0 string) {
+		*k, *n, *split = k0, n0, split0
+	}(*k, *n, *split)
+	*k, *n = 3, 10
+	dir := t.TempDir()
+	file := filepath.Join(dir, "x.fa")
+	data := ">a_1 first\nAAAC\n>b_1\nGGGT\n>a_2\nAAAC\n"
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mapFile := filepath.Join(dir, "map.tsv")
+	if err := os.WriteFile(mapFile, []byte("a_1\tx\nb_1\tx\na_2\ty\n"),
+		0o644); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	splitMap, err = readSplitMap(mapFile)
+	if err != nil {
+		t.Fatalf("readSplitMap(%q) failed: %v", mapFile, err)
+	}
+	defer func() { splitMap = nil }()
+
+	tests := []struct {
+		split   string
+		names   []string
+		records [][]string
+	}{
+		{"record", []string{"a_1", "b_1", "a_2"},
+			[][]string{{"a_1"}, {"b_1"}, {"a_2"}}},
+		{"prefix", []string{"a", "b"}, [][]string{{"a_1", "a_2"}, {"b_1"}}},
+		{"map", []string{"x", "y"}, [][]string{{"a_1", "b_1"}, {"a_2"}}},
+	}
+	for _, test := range tests {
+		*split = test.split
+		sketches, records, err := splitSketches(file)
+		if err != nil {
+			t.Fatalf("splitSketches(%q) failed: %v", test.split, err)
+		}
+		var names []string
+		for _, s := range sketches {
+			names = append(names, s.name)
+		}
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("splitSketches(%q) names=%v, want %v",
+				test.split, names, test.names)
+		}
+		if !reflect.DeepEqual(records, test.records) {
+			t.Errorf("splitSketches(%q) records=%v, want %v",
+				test.split, records, test.records)
+		}
+	}
+
+	// Leaves with the same k-mers get the same sketch.
+	*split = "record"
+	sketches, _, err := splitSketches(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := sketches[0].mh.View(), sketches[2].mh.View()
+	if !reflect.DeepEqual(a, b) || len(a) != 2 {
+		t.Errorf("sketches of a_1 and a_2: %v and %v, want two equal hashes",
+			a, b)
+	}
+}
@@ -2,86 +2,336 @@
 package main
 
 import (
+	"bytes"
 	"crypto/md5"
 	"encoding/base32"
 	"flag"
 	"fmt"
 	"iter"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
 
-	"github.com/fluhus/biostuff/formats/fasta"
+	"github.com/fluhus/biostuff/formats/newick"
 	"github.com/fluhus/biostuff/sequtil"
 	"github.com/fluhus/frackyfrac/common"
+	"github.com/fluhus/gostuff/jio"
+	"github.com/fluhus/gostuff/minhash"
 	"github.com/fluhus/gostuff/ppln"
 	"github.com/fluhus/gostuff/ptimer"
 	"golang.org/x/exp/maps"
 )
 
 var (
-	k    = flag.Uint("k", 0, "K-mer length, required")
-	n    = flag.Uint("n", 10000, "Sketch length")
-	fout = flag.String("o", "", "Path to output file (default stdout)")
-	keep = flag.Bool("keep-temp", false, "Do not remove temporary files")
-	nt   = flag.Int("t", 1, "Number of threads")
+	k      = flag.Uint("k", 0, "K-mer length, required")
+	n      = flag.Uint("n", 10000, "Sketch length")
+	scaled = flag.Uint("scaled", 0, "Use FracMinHash sketches that keep "+
+		"1/scaled of the k-mer hashes, instead of -n hashes")
+	seed = flag.Uint("seed", 0, "Hash seed (default 42 with -compat, for "+
+		"compatibility with sourmash and Mash)")
+	molecule = flag.String("molecule", "dna", "Input sequence type: dna, "+
+		"or protein with the full (protein) or a reduced alphabet (dayhoff, "+
+		"hp for hydrophobic-polar)")
+	metric = flag.String("distance", "mash", "Distance between sketches: "+
+		"mash or ani (1 minus ANI by maximal containment, needs -scaled)")
+	fout   = flag.String("o", "", "Path to output file (default stdout)")
+	keep   = flag.Bool("keep-temp", false, "Do not remove temporary files")
+	nt     = flag.Int("t", 1, "Number of threads")
+	method = flag.String("method", "agglo", "Tree building method: agglo "+
+		"(agglomerative clustering, see -linkage), nj (neighbor joining) or "+
+		"fastnj (neighbor joining with RapidNJ's search); neighbor-joining "+
+		"trees are rooted at their midpoint")
+	linkage = flag.String("linkage", "average", "Linkage for agglomerative "+
+		"clustering: average (UPGMA), single, complete or wpgma")
+	fit = flag.Bool("fit", false, "Refine branch lengths by least squares "+
+		"on the tree's topology")
+	fitIters = flag.Int("fit-iters", 1000, "Maximal number of -fit "+
+		"iterations, each taking time quadratic in the number of genomes; "+
+		"fitting stops earlier once it converges")
+	dbDir = flag.String("db", "", "Sketch database directory, for reusing "+
+		"sketches across runs")
+	cacheDist = flag.Bool("cache-dist", false, "Store distances in the "+
+		"sketch database, computing only those of new genomes (add and tree)")
+	insert = flag.Bool("insert", false, "Insert new genomes into the "+
+		"database's last tree instead of rebuilding it (add and tree)")
+	distOut = flag.String("dist-out", "", "Write the distances to this "+
+		"file, in the order of the tree's input")
+	distFormat = flag.String("dist-format", "pyramid", "Format of the "+
+		"distances file: pyramid (one value per line, like frcfrc) or "+
+		"square (labeled tab-separated matrix)")
+	compat = flag.Bool("compat", false, "Hash k-mers like sourmash and "+
+		"Mash, with their seed of 42 and, for DNA, uppercase sequences "+
+		"without k-mers with characters other than ACGT; implied by "+
+		"-sig-out, -mash-out and sketch file inputs")
+	sigOut = flag.String("sig-out", "", "Write the tree's sketches to this "+
+		"file as sourmash signatures")
+	mashOut = flag.String("mash-out", "", "Write the tree's sketches to "+
+		"this file as a Mash JSON dump, like the output of 'mash info -d'")
+	distIn = flag.String("dists", "", "Build the tree from this distances "+
+		"file instead of fasta files, in either format of -dist-format")
+	namesIn = flag.String("names", "", "Leaf names for a pyramid -dists "+
+		"file, one per line (default row numbers)")
+	minCount = flag.Uint("min-count", 1, "Sketch only k-mers that appear "+
+		"at least this many times in fastq files, for filtering out "+
+		"sequencing errors")
+	split = flag.String("split", "file", "Leaves of each fasta file: file "+
+		"(one leaf), record (a leaf per record, named by its first word), "+
+		"prefix (a leaf per record name prefix, up to -split-sep) or map "+
+		"(leaves by -split-map)")
+	splitSep = flag.String("split-sep", "_", "Separator that ends the "+
+		"record name prefixes of -split prefix")
+	splitMapFile = flag.String("split-map", "", "File of record names and "+
+		"their leaf names, separated by a tab, for -split map")
 )
 
+// Subcommands, which work with a sketch database.
+var commands = map[string]bool{"sketch": true, "tree": true, "add": true}
+
+// The subcommand in the arguments, or empty if none.
+var command string
+
 func main() {
 	common.ExitIfError(parseArgs())
+	if *split == "map" {
+		var err error
+		splitMap, err = readSplitMap(*splitMapFile)
+		common.ExitIfError(err)
+	}
+
+	var db *sketchDB
+	var names []string
+	var sketches []*minhash.MinHash[uint64]
+	var err error
+	switch {
+	case *distIn != "":
+		fmt.Fprintln(os.Stderr, "Reading distances")
+		var distances []float64
+		distances, names, err = readDistanceMatrix(*distIn, *namesIn)
+		common.ExitIfError(err)
+		fmt.Fprintln(os.Stderr, "Building tree")
+		tree, err := treeFromDistances(distances, names)
+		common.ExitIfError(err)
+		common.ExitIfError(writeTree(tree))
+		return
+	case *dbDir != "":
+		db, names, sketches, err = sketchesFromDB()
+	default:
+		names, sketches, err = sketchesFromTemp()
+	}
+	common.ExitIfError(err)
+	if *sigOut != "" {
+		fmt.Fprintln(os.Stderr, "Writing signatures")
+		common.ExitIfError(writeSourmash(*sigOut, names, sketches))
+	}
+	if *mashOut != "" {
+		fmt.Fprintln(os.Stderr, "Writing Mash sketches")
+		common.ExitIfError(writeMash(*mashOut, names, sketches))
+	}
+	if command == "sketch" {
+		return
+	}
+	if len(names) == 0 {
+		common.ExitIfError(fmt.Errorf("no genomes to build a tree from"))
+	}
+
+	fmt.Fprintln(os.Stderr, "Building tree")
+	pt := ptimer.New()
+	var tree *newick.Node
+	if command == "" {
+		tree, err = makeTree(sketches, names)
+	} else {
+		tree, err = dbTree(db, sketches, names)
+	}
+	common.ExitIfError(err)
+	pt.Done()
+	common.ExitIfError(writeTree(tree))
+}
+
+// Writes the tree to the output.
+func writeTree(tree *newick.Node) error {
+	treeText, _ := tree.MarshalText()
+	if *fout == "" {
+		fmt.Printf("%s\n", treeText)
+		return nil
+	}
+	return os.WriteFile(*fout, treeText, 0o644)
+}
 
+// Sketches the input files into a temporary directory. Returns the leaf names
+// and sketches.
+func sketchesFromTemp() ([]string, []*minhash.MinHash[uint64], error) {
 	files := expandFiles()
 	if len(files) == 0 {
-		common.ExitIfError(fmt.Errorf("no input files"))
+		return nil, nil, fmt.Errorf("no input files")
 	}
 	fmt.Fprintln(os.Stderr, "Sketching", len(files), "files")
 
 	tmp, err := os.MkdirTemp("", "trtr-")
-	common.ExitIfError(err)
+	if err != nil {
+		return nil, nil, err
+	}
 	if !*keep {
 		defer os.RemoveAll(tmp)
 	}
 	fmt.Fprintln(os.Stderr, "Temp dir:", tmp)
 
 	pt := ptimer.NewMessage("{} files sketched")
-	var sketchFiles []string
-	ppln.Serial[string, string](
+	var names []string
+	var sketches []*minhash.MinHash[uint64]
+	err = ppln.Serial(
 		*nt,
-		func(yield func(string, error) bool) {
-			for _, file := range files {
-				if !yield(file, nil) {
-					break
+		ppln.SliceInput(files),
+		func(file string, i, g int) ([]namedSketch, error) {
+			result, err := fileSketches(file)
+			if err != nil {
+				return nil, err
+			}
+			for j, s := range result {
+				fout := filepath.Join(tmp, fmt.Sprintf("sketch_%s_%d.json.gz",
+					strhash(file), j+1))
+				if err := jio.Write(fout, s.mh); err != nil {
+					return nil, err
 				}
 			}
+			return result, nil
 		},
-		func(file string, i, g int) (string, error) {
-			fout := filepath.Join(tmp, "sketch_"+strhash(file)+".json.gz")
-			return fout, sketchFile(file, fout)
-		},
-		func(f string) error {
-			sketchFiles = append(sketchFiles, f)
+		func(result []namedSketch) error {
+			for _, s := range result {
+				names = append(names, s.name)
+				sketches = append(sketches, s.mh)
+			}
 			pt.Inc()
 			return nil
 		})
+	if err != nil {
+		return nil, nil, err
+	}
 	pt.Done()
+	return names, sketches, nil
+}
 
-	fmt.Fprintln(os.Stderr, "Loading sketches")
-	pt = ptimer.New()
-	sketches, err := loadSketches(sketchFiles)
-	common.ExitIfError(err)
-	pt.Done()
+// Adds the input files to the sketch database, according to the subcommand.
+// Returns the database, and the leaf names and sketches for the tree: all the
+// genomes in the database for the commands, or the input files without a
+// command. The sketch command returns no sketches unless -sig-out or -mash-out
+// is set.
+func sketchesFromDB() (*sketchDB, []string, []*minhash.MinHash[uint64],
+	error) {
+	var want sketchParams
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "k":
+			want.K = *k
+		case "n":
+			want.N = *n
+		case "scaled":
+			want.Scaled = *scaled
+		case "seed":
+			want.Seed = *seed
+		case "molecule":
+			want.Molecule = *molecule
+		case "min-count":
+			want.MinCount = *minCount
+		}
+	})
+	want.Compat = *compat
+	db, err := openDB(*dbDir, want, command != "tree")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	*k, *n, *scaled, *seed = db.K, db.N, db.Scaled, db.Seed
+	*molecule = db.molecule()
+	*minCount = max(db.MinCount, 1)
+	*compat = db.Compat
+	if *metric == "ani" && *scaled == 0 {
+		return nil, nil, nil, fmt.Errorf("ani distance needs a database " +
+			"with -scaled sketches")
+	}
 
-	fmt.Fprintln(os.Stderr, "Building tree")
-	pt = ptimer.New()
-	tree := makeTree(sketches, baseNames(files))
+	genomes := db.Genomes
+	if command != "tree" {
+		files := expandFiles()
+		if len(files) == 0 {
+			return nil, nil, nil, fmt.Errorf("no input files")
+		}
+		fmt.Fprintln(os.Stderr, "Sketching", len(files), "files")
+		added, err := db.addFiles(files)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if command == "" {
+			genomes = added
+		} else {
+			genomes = db.Genomes
+		}
+	}
+	if command == "sketch" {
+		fmt.Fprintln(os.Stderr, "Database has", len(db.Genomes), "genomes")
+		if *sigOut == "" && *mashOut == "" {
+			return db, nil, nil, nil
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "Loading sketches")
+	pt := ptimer.New()
+	sketches, err := db.loadSketches(genomes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	pt.Done()
+	names := make([]string, len(genomes))
+	for i, g := range genomes {
+		names[i] = g.Name
+	}
+	return db, names, sketches, nil
+}
 
-	treeText, _ := tree.MarshalText()
-	if *fout == "" {
-		fmt.Printf("%s\n", treeText)
+// Creates a tree of all the genomes in the database and stores it in the
+// database. With -insert, inserts the genomes that are not in the last stored
+// tree into it instead of building a new one.
+func dbTree(db *sketchDB, sketches []*minhash.MinHash[uint64],
+	names []string) (*newick.Node, error) {
+	var tree *newick.Node
+	if *insert && db.Tree > 0 {
+		fmt.Fprintln(os.Stderr, "Inserting", len(names)-db.Tree,
+			"genomes into the last tree")
+		var err error
+		tree, err = db.readTree()
+		if err != nil {
+			return nil, err
+		}
+		rows, err := db.distanceRows(sketches, db.Tree, *cacheDist)
+		if err != nil {
+			return nil, err
+		}
+		tree, err = insertLeaves(tree, names, db.Tree, rows)
+		if err != nil {
+			return nil, err
+		}
+		if *fit {
+			distances, err := db.distanceRows(sketches, 0, true)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintln(os.Stderr, "Fitting branch lengths")
+			if err := fitBranchLengths(tree, distances, names, *fitIters); err != nil {
+				return nil, err
+			}
+		}
 	} else {
-		common.ExitIfError(os.WriteFile(*fout, treeText, 0o644))
+		distances, err := db.distanceRows(sketches, 0, *cacheDist)
+		if err != nil {
+			return nil, err
+		}
+		tree, err = treeFromDistances(distances, names)
+		if err != nil {
+			return nil, err
+		}
 	}
+	return tree, db.writeTree(tree)
 }
 
 // Parses and checks arguments.
@@ -91,11 +341,116 @@ func parseArgs() error {
 		os.Exit(1)
 	}
 	flag.Usage = usage
-	flag.Parse()
+	if commands[os.Args[1]] {
+		command = os.Args[1]
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 
-	if *k == 0 {
+	if command != "" && *dbDir == "" {
+		return fmt.Errorf("please provide a sketch database with -db")
+	}
+	if (*cacheDist || *insert) && command != "add" && command != "tree" {
+		return fmt.Errorf("-cache-dist and -insert work with the add and " +
+			"tree commands")
+	}
+	if *fitIters < 0 {
+		return fmt.Errorf("-fit-iters should be non-negative, got %d",
+			*fitIters)
+	}
+	if *insert && *fit && !*cacheDist {
+		return fmt.Errorf("-fit with -insert needs -cache-dist")
+	}
+	if command == "tree" && flag.NArg() > 0 {
+		return fmt.Errorf("tree takes no input files, use add to add them")
+	}
+	if *distIn != "" && (command != "" || *dbDir != "" || flag.NArg() > 0) {
+		return fmt.Errorf("-dists does not work with fasta files or -db")
+	}
+	if *namesIn != "" && *distIn == "" {
+		return fmt.Errorf("-names works with -dists")
+	}
+	if *distOut != "" && *insert {
+		return fmt.Errorf("-dist-out does not work with -insert")
+	}
+	if _, ok := alphabets[*molecule]; !ok && *molecule != "dna" {
+		return fmt.Errorf("unknown molecule: %q", *molecule)
+	}
+	switch *metric {
+	case "mash", "ani":
+	default:
+		return fmt.Errorf("unknown distance: %q", *metric)
+	}
+	if *metric == "ani" && *scaled == 0 && *dbDir == "" && *distIn == "" {
+		return fmt.Errorf("ani distance needs -scaled")
+	}
+	bothSizes := 0
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "n" || f.Name == "scaled" {
+			bothSizes++
+		}
+	})
+	if bothSizes == 2 {
+		return fmt.Errorf("-n and -scaled cannot be used together")
+	}
+	if *mashOut != "" && *scaled != 0 {
+		return fmt.Errorf("-mash-out does not work with -scaled")
+	}
+	if *seed > math.MaxUint32 {
+		return fmt.Errorf("seed should be at most %d", math.MaxUint32)
+	}
+	if *minCount > math.MaxUint16 {
+		return fmt.Errorf("-min-count should be at most %d", math.MaxUint16)
+	}
+	switch *split {
+	case "file", "record", "prefix", "map":
+	default:
+		return fmt.Errorf("unknown split: %q", *split)
+	}
+	if (*split == "map") != (*splitMapFile != "") {
+		return fmt.Errorf("-split map needs -split-map and vice versa")
+	}
+	if *split == "prefix" && *splitSep == "" {
+		return fmt.Errorf("-split prefix needs a non-empty -split-sep")
+	}
+	if *split != "file" && *minCount > 1 {
+		return fmt.Errorf("-min-count does not work with -split")
+	}
+	switch *distFormat {
+	case "pyramid", "square":
+	default:
+		return fmt.Errorf("unknown distances format: %q", *distFormat)
+	}
+	if *k == 0 && *dbDir == "" && *distIn == "" {
 		return fmt.Errorf("please provide a kmer length with -k")
 	}
+	switch *method {
+	case "agglo", "nj", "fastnj":
+	default:
+		return fmt.Errorf("unknown tree building method: %q", *method)
+	}
+	switch *linkage {
+	case "average", "single", "complete", "wpgma":
+	default:
+		return fmt.Errorf("unknown linkage: %q", *linkage)
+	}
+	if *sigOut != "" || *mashOut != "" ||
+		slices.ContainsFunc(flag.Args(), isSketchFile) {
+		*compat = true
+	}
+	if *compat {
+		seedSet := false
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "seed" {
+				seedSet = true
+			}
+		})
+		if !seedSet {
+			// Set as a flag, so that a new database gets it too.
+			flag.Set("seed", "42")
+		}
+	}
 
 	return nil
 }
@@ -116,12 +471,12 @@ func expandFiles() []string {
 
 func iterKmers(file string, k int) iter.Seq2[[]byte, error] {
 	return func(yield func([]byte, error) bool) {
-		for fa, err := range fasta.File(file) {
+		for fa, err := range iterRecords(file) {
 			if err != nil {
 				yield(nil, err)
 				return
 			}
-			for kmer := range sequtil.CanonicalSubsequences(fa.Sequence, k) {
+			for kmer := range seqKmers(fa.Sequence, k) {
 				if !yield(kmer, nil) {
 					return
 				}
@@ -130,6 +485,36 @@ func iterKmers(file string, k int) iter.Seq2[[]byte, error] {
 	}
 }
 
+// Iterates over the k-mers of a sequence, of the molecule type in the
+// arguments. DNA k-mers are canonical, and are cleaned up with -compat.
+func seqKmers(seq []byte, k int) iter.Seq[[]byte] {
+	if *molecule != "dna" {
+		return proteinKmers(seq, k, *molecule)
+	}
+	if !*compat {
+		return sequtil.CanonicalSubsequences(seq, k)
+	}
+	return func(yield func([]byte) bool) {
+		// Like in Mash and sourmash, k-mers are uppercase and ones with
+		// characters other than ACGT are skipped.
+		seq := bytes.ToUpper(seq)
+		for len(seq) > 0 {
+			i := bytes.IndexFunc(seq, func(r rune) bool {
+				return !strings.ContainsRune("ACGT", r)
+			})
+			if i == -1 {
+				i = len(seq)
+			}
+			for kmer := range sequtil.CanonicalSubsequences(seq[:i], k) {
+				if !yield(kmer) {
+					return
+				}
+			}
+			seq = seq[min(i+1, len(seq)):]
+		}
+	}
+}
+
 // A convenience function for hashing strings.
 func strhash(x string) string {
 	h := md5.New()
@@ -137,15 +522,6 @@ func strhash(x string) string {
 	return base32.StdEncoding.EncodeToString(h.Sum(nil))[:20]
 }
 
-// Returns the basenames of the given files.
-func baseNames(files []string) []string {
-	result := make([]string, len(files))
-	for i, f := range files {
-		result[i] = filepath.Base(f)
-	}
-	return result
-}
-
 // Prints usage help message.
 func usage() {
 	fmt.Fprintln(flag.CommandLine.Output(),
@@ -153,9 +529,37 @@ func usage() {
 
 Usage:
 trtr [PARAMS] species1.fa species2.fa species3.fa ...
+trtr sketch -db DIR -k K [PARAMS] species1.fa species2.fa ...
+trtr add -db DIR [PARAMS] species4.fa species5.fa ...
+trtr tree -db DIR [PARAMS]
+trtr -dists distances.txt [PARAMS]
 
 File names may be glob patterns with '*', '?', and '[abc123]'.
 
+Besides fasta files, inputs may be sourmash signatures (.sig or .json) and
+Mash sketches dumped to JSON with 'mash info -d' (.json). Their k and seed
+should match -k and -seed, and they should be at least as large as -n, or
+have a scale of at most -scaled. Binary Mash sketches (.msh) are not
+supported. Sketches can be written back with -sig-out and -mash-out.
+
+Fasta and fastq files may be gzipped. For reads in fastq files, use -min-count
+to skip k-mers that appear fewer times, which mostly come from sequencing
+errors.
+
+By default each fasta file is one leaf. With -split, the records of a
+multi-fasta file become leaves of their own, or are grouped into leaves by a
+prefix of their names or by a map file.
+
+With -db, sketches are kept in a database directory and files that were
+already sketched are not sketched again. The sketch command adds files to the
+database, creating it if needed. The add command adds files and creates a tree
+of all the genomes in the database, and the tree command only creates it. A
+database keeps the k and n it was created with.
+
+With -cache-dist, the distances are stored in the database too, and only the
+distances of new genomes are computed. With -insert, each new genome is added
+to the last tree next to its nearest genome, instead of building a new tree.
+
 Params:`)
 	flag.PrintDefaults()
 }